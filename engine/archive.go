@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Archive store songs, which were already downloaded, so they can be
+// skipped next time. Songs are identified by extractor's name and song's ID.
+// Implementations must be safe for concurrent use
+type Archive interface {
+	Has(extractor string, id string) (bool, error)
+	Add(extractor string, id string) error
+}
+
+// FileArchive is an Archive stored in text file, one "extractor id" line
+// per song, same as youtube-dl's download archive
+type FileArchive struct {
+	path  string
+	mu    sync.RWMutex
+	songs map[string]bool
+}
+
+// NewFileArchive load archive from file. File is created on first Add,
+// if it doesn't exist yet
+func NewFileArchive(path string) (*FileArchive, error) {
+	a := &FileArchive{path: path, songs: make(map[string]bool)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 {
+			a.songs[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *FileArchive) Has(extractor string, id string) (bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.songs[archiveKey(extractor, id)], nil
+}
+
+// Add append song to archive's file
+func (a *FileArchive) Add(extractor string, id string) error {
+	key := archiveKey(extractor, id)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.songs[key] {
+		return nil
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, key); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.songs[key] = true
+	return nil
+}
+
+func archiveKey(extractor string, id string) string {
+	return strings.ToLower(extractor) + " " + id
+}
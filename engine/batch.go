@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// defaultExtractWorkers is a number of urls extracted at once
+	defaultExtractWorkers = 4
+	// defaultDownloadWorkers is a number of songs downloaded (and processed
+	// by ffmpeg) at once
+	defaultDownloadWorkers = 2
+)
+
+// BatchResult is a processing result of single message from batch.
+// If song can't be processed - Song is nil and Err contain reason
+type BatchResult struct {
+	// Index of message in input slice
+	Index   int
+	Message string
+	Song    *SongResult
+	Err     error
+}
+
+// ProcessBatch process every message same as Process, but concurrently.
+// Number of simultaneous extractions and downloads is limited by
+// Config.ExtractWorkers and Config.DownloadWorkers.
+// Results are returned in input order
+func (e Engine) ProcessBatch(messages []string) []BatchResult {
+	return e.ProcessBatchContext(context.Background(), messages)
+}
+
+// ProcessBatchContext same as ProcessBatch, but whole process will be
+// aborted when ctx is done. Unfinished messages get ErrCancelled
+func (e Engine) ProcessBatchContext(ctx context.Context, messages []string) []BatchResult {
+	results := make([]BatchResult, len(messages))
+	for res := range e.StreamBatch(ctx, messages) {
+		results[res.Index] = res
+	}
+	return results
+}
+
+// ProcessAll process every url found in message. Return ErrNotURL if there
+// are no urls at all, otherwise results are returned same as ProcessBatch,
+// one for each url
+func (e Engine) ProcessAll(message string) ([]BatchResult, error) {
+	return e.ProcessAllContext(context.Background(), message)
+}
+
+// ProcessAllContext same as ProcessAll, but whole process will be aborted
+// when ctx is done
+func (e Engine) ProcessAllContext(ctx context.Context, message string) ([]BatchResult, error) {
+	urls := ExtractURLs(message)
+	if len(urls) < 1 {
+		return nil, ErrNotURL{}
+	}
+	messages := make([]string, len(urls))
+	for i, u := range urls {
+		messages[i] = u.String()
+	}
+	return e.ProcessBatchContext(ctx, messages), nil
+}
+
+// StreamBatch same as ProcessBatchContext, but return results as soon as
+// they are ready, in order of completion. Channel is closed after the last
+// result. It must be read till the end, otherwise workers are blocked
+func (e Engine) StreamBatch(ctx context.Context, messages []string) <-chan BatchResult {
+	type extracted struct {
+		index int
+		song  *SongInfo
+	}
+	jobs := make(chan int)
+	songs := make(chan extracted)
+	results := make(chan BatchResult)
+
+	go func() {
+		defer close(jobs)
+		for i := range messages {
+			jobs <- i
+		}
+	}()
+
+	var extractors sync.WaitGroup
+	for w := 0; w < workers(e.config.ExtractWorkers, defaultExtractWorkers); w++ {
+		extractors.Add(1)
+		go func() {
+			defer extractors.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					results <- BatchResult{Index: i, Message: messages[i], Err: ErrCancelled{ctx.Err()}}
+					continue
+				}
+				song, err := e.GetInfoContext(ctx, messages[i])
+				if err != nil {
+					results <- BatchResult{Index: i, Message: messages[i], Err: err}
+					continue
+				}
+				songs <- extracted{i, song}
+			}
+		}()
+	}
+	go func() {
+		extractors.Wait()
+		close(songs)
+	}()
+
+	var downloaders sync.WaitGroup
+	for w := 0; w < workers(e.config.DownloadWorkers, defaultDownloadWorkers); w++ {
+		downloaders.Add(1)
+		go func() {
+			defer downloaders.Done()
+			for s := range songs {
+				res := BatchResult{Index: s.index, Message: messages[s.index]}
+				if ctx.Err() != nil {
+					res.Err = ErrCancelled{ctx.Err()}
+				} else {
+					res.Song, res.Err = s.song.GetContext(ctx)
+				}
+				results <- res
+			}
+		}()
+	}
+	go func() {
+		extractors.Wait()
+		downloaders.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// workers return n if it's positive, otherwise def
+func workers(n int, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
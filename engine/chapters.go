@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+// ChapterMode define what to do with songs, which have chapters
+type ChapterMode int
+
+const (
+	// ChaptersIgnore download song as single file without chapters
+	ChaptersIgnore ChapterMode = iota
+	// ChaptersMarkers write chapters as markers inside single file
+	ChaptersMarkers
+	// ChaptersSplit save every chapter into separate file, named by
+	// Config.ChapterTemplate. It requires loader, which can split files,
+	// like ffmpeg. Without one - chapters are written as markers
+	ChaptersSplit
+)
+
+// DefaultChapterTemplate is used for names of chapter files when
+// Config.ChapterTemplate is empty
+const DefaultChapterTemplate = "{album}/{track} - {title}.{ext}"
+
+// chapterMarkers convert song's chapters into tags. Chapter without end
+// lasts till the end of song
+func chapterMarkers(info *parsers.ExtractorInfo) []loaders.Chapter {
+	markers := make([]loaders.Chapter, 0, len(info.Chapters))
+	for _, ch := range info.Chapters {
+		end := ch.End
+		if end < 1 {
+			end = info.Duration
+		}
+		markers = append(markers, loaders.Chapter{Title: ch.Title, Start: ch.Start, End: end})
+	}
+	return markers
+}
+
+// splitter return the first loader, which can split files
+func (e Engine) splitter() loaders.Splitter {
+	for _, ldr := range e.sortedLoaders() {
+		if s, ok := ldr.(loaders.Splitter); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// chapterSong return info and tags of i-th chapter of song. Chapter
+// titles like "Artist - Title" are split, album is named after song
+func chapterSong(info *parsers.ExtractorInfo, tags loaders.Tags, i int) (*parsers.ExtractorInfo, loaders.Tags) {
+	ch := info.Chapters[i]
+	chInfo := *info
+	chInfo.Title, chInfo.Featured = ch.Title, nil
+	tags.Title = ch.Title
+	if artist, title, ok := splitArtistTitle(ch.Title); ok {
+		chInfo.Title, chInfo.Artist = title, artist
+		tags.Title, tags.Artists = title, []string{artist}
+	}
+	tags.Track = i + 1
+	tags.Lyrics = loaders.Lyrics{}
+	tags.Chapters = nil
+	return &chInfo, tags
+}
+
+// splitChapters cut downloaded file into chapters of info, tag them with
+// ldr and move into output folder. Info has to describe downloaded part of
+// song, so chapters of trimmed song are cut at right offsets. Chapters,
+// which files already exist and Config.Collision is CollisionSkip, are
+// skipped. Return paths of written files. If process fails - every written
+// file is removed
+func (e Engine) splitChapters(ctx context.Context, song *SongInfo, info *parsers.ExtractorInfo, ldr loaders.Loader,
+	splitter loaders.Splitter, filename string, ext string, tags loaders.Tags, thumbnail string) ([]string, error) {
+	tmpl := e.config.ChapterTemplate
+	if len(tmpl) < 1 {
+		tmpl = DefaultChapterTemplate
+	}
+	var paths []string
+	var skipped error
+	fail := func(err error) ([]string, error) {
+		for _, p := range paths {
+			_ = os.Remove(p)
+		}
+		if ctx.Err() != nil {
+			return nil, ErrCancelled{ctx.Err()}
+		}
+		return nil, ErrDownloadingError{Reason: err.Error()}
+	}
+	song.report(StageSplitting, loaders.Progress{})
+	for i, ch := range info.Chapters {
+		chInfo, chTags := chapterSong(info, tags, i)
+		fields := templateFields(chInfo, ext)
+		fields["album"] = info.Title
+		fields["track"] = fmt.Sprintf("%02d", i+1)
+		outPath := path.Join(e.outputFolder, renderFields(tmpl, fields, chInfo, ext, e.config.ASCIIFilenames))
+
+		tempPath, err := makeTempPath(e.outputFolder, ext)
+		if err != nil {
+			return fail(err)
+		}
+		if err := splitter.Split(ctx, filename, tempPath, ch.Start, ch.End); err != nil {
+			_ = os.Remove(tempPath)
+			return fail(err)
+		}
+		if err := ldr.UpdateTags(ctx, tempPath, chTags); err != nil {
+			log.Println(err)
+		}
+		if len(thumbnail) > 0 {
+			if err := ldr.AddThumbnail(ctx, tempPath, thumbnail); err != nil {
+				log.Println(err)
+			}
+		}
+		if ctx.Err() != nil {
+			_ = os.Remove(tempPath)
+			return fail(ctx.Err())
+		}
+		finalPath, err := reserveFile(outPath, e.config.Collision)
+		if err != nil {
+			_ = os.Remove(tempPath)
+			if _, ok := err.(ErrFileExists); ok {
+				skipped = err
+				continue
+			}
+			return fail(err)
+		}
+		if err := os.Rename(tempPath, finalPath); err != nil {
+			_ = os.Remove(tempPath)
+			return fail(err)
+		}
+		paths = append(paths, finalPath)
+	}
+	if len(paths) < 1 && skipped != nil {
+		return nil, skipped
+	}
+	return paths, nil
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+// CollectionInfo contain info about every song from multi-track source,
+// like playlist
+type CollectionInfo struct {
+	Title     string
+	Permalink string
+	Uploader  string
+	Entries   []CollectionEntry
+}
+
+// CollectionEntry is a single song of collection. If song can't be
+// extracted - Song is nil and Err contain reason
+type CollectionEntry struct {
+	Song *SongInfo
+	Err  error
+}
+
+// CollectionResult contain downloading result of every collection's song
+type CollectionResult struct {
+	Title     string
+	Permalink string
+	Uploader  string
+	Entries   []CollectionEntryResult
+}
+
+// CollectionEntryResult is a downloading result of single song. If song
+// can't be downloaded - Song is nil and Err contain reason
+type CollectionEntryResult struct {
+	Song *SongResult
+	Err  error
+}
+
+// ProcessCollection your message with multi-track url (playlist, etc) and
+// download every song from it. Errors of certain songs don't interrupt
+// process and returned inside CollectionResult.Entries
+func (e Engine) ProcessCollection(s string) (*CollectionResult, error) {
+	return e.ProcessCollectionContext(context.Background(), s)
+}
+
+// ProcessCollectionContext same as ProcessCollection, but whole process will
+// be aborted when ctx is done
+func (e Engine) ProcessCollectionContext(ctx context.Context, s string) (*CollectionResult, error) {
+	collection, err := e.GetCollectionInfoContext(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return collection.GetContext(ctx)
+}
+
+func (e Engine) GetCollectionInfo(s string) (*CollectionInfo, error) {
+	return e.GetCollectionInfoContext(context.Background(), s)
+}
+
+// GetCollectionInfoContext same as GetCollectionInfo, but extracting will be
+// aborted when ctx is done
+func (e Engine) GetCollectionInfoContext(ctx context.Context, s string) (*CollectionInfo, error) {
+	u, ok := ExtractURL(s)
+	if !ok {
+		return nil, ErrNotURL{}
+	}
+	e.report(Progress{URL: u.String(), Stage: StageExtracting})
+	collection, err := e.extractCollection(ctx, *u)
+	if err != nil {
+		return nil, err
+	}
+	info := &CollectionInfo{
+		Title:     collection.Title,
+		Permalink: collection.Permalink,
+		Uploader:  collection.Uploader,
+		Entries:   make([]CollectionEntry, 0, len(collection.Entries)),
+	}
+	for _, entry := range collection.Entries {
+		if entry.Err != nil {
+			info.Entries = append(info.Entries, CollectionEntry{Err: convertExtractorErr(ctx, entry.Err)})
+			continue
+		}
+		song, err := e.newSongInfo(entry.Info, u.String())
+		info.Entries = append(info.Entries, CollectionEntry{Song: song, Err: err})
+	}
+	return info, nil
+}
+
+func (c *CollectionInfo) Get() (*CollectionResult, error) {
+	return c.GetContext(context.Background())
+}
+
+// GetContext download every song of collection. When ctx is done - downloading
+// stops, ErrCancelled returned along with already downloaded songs
+func (c *CollectionInfo) GetContext(ctx context.Context) (*CollectionResult, error) {
+	result := &CollectionResult{
+		Title:     c.Title,
+		Permalink: c.Permalink,
+		Uploader:  c.Uploader,
+		Entries:   make([]CollectionEntryResult, 0, len(c.Entries)),
+	}
+	for _, entry := range c.Entries {
+		if ctx.Err() != nil {
+			return result, ErrCancelled{ctx.Err()}
+		}
+		if entry.Err != nil {
+			result.Entries = append(result.Entries, CollectionEntryResult{Err: entry.Err})
+			continue
+		}
+		song, err := entry.Song.GetContext(ctx)
+		if _, ok := err.(ErrCancelled); ok {
+			return result, err
+		}
+		result.Entries = append(result.Entries, CollectionEntryResult{Song: song, Err: err})
+	}
+	return result, nil
+}
+
+func (e Engine) extractCollection(ctx context.Context, u url.URL) (*parsers.Collection, error) {
+	for _, xtr := range e.extractors {
+		if !xtr.Compatible(u) {
+			continue
+		}
+		cxtr, ok := xtr.(parsers.CollectionExtractor)
+		if !ok {
+			return nil, ErrUnsupportedType{parsers.ErrFormatNotSupported{Format: "collection"}}
+		}
+		collection, err := cxtr.ExtractCollection(ctx, u, e.config.Collection)
+		if err != nil {
+			return nil, convertExtractorErr(ctx, err)
+		}
+		for _, entry := range collection.Entries {
+			if entry.Info != nil {
+				entry.Info.Extractor = xtr.Name()
+			}
+		}
+		return collection, nil
+	}
+	return nil, ErrUnsupportedService{Service: u.Hostname()}
+}
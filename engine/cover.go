@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // decode png covers
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/camelva/erzo/parsers"
+	"github.com/camelva/erzo/utils"
+)
+
+const (
+	// _maxJPEGQuality is a quality of re-encoded covers, which is lowered
+	// while cover doesn't fit into CoverOptions.MaxBytes
+	_maxJPEGQuality = 90
+	_minJPEGQuality = 40
+	// _minCoverSide is a minimal side of cover, which is shrunk to fit
+	// into CoverOptions.MaxBytes
+	_minCoverSide = 100
+)
+
+// CoverOptions define how cover is chosen from song's artworks and
+// processed before embedding. Zero value embed the biggest artwork as is
+type CoverOptions struct {
+	// Skip disable covers
+	Skip bool
+	// Size is a preferred side of cover in pixels. The smallest artwork,
+	// which isn't smaller than Size, is chosen; if there is none - the
+	// biggest one. If it can't be downloaded - the next closest one is
+	// tried. 0 means the biggest artwork
+	Size int
+	// Square crop cover to square by its center
+	Square bool
+	// MaxSide shrink cover, so none of its sides is bigger than MaxSide
+	MaxSide int
+	// MaxBytes re-encode cover with lower quality (and size, if needed)
+	// until it fit into MaxBytes
+	MaxBytes int
+}
+
+// process report whether cover has to be decoded and re-encoded
+func (o CoverOptions) process() bool {
+	return o.Square || o.MaxSide > 0 || o.MaxBytes > 0
+}
+
+// prepareCover download song's cover, process it according to engine's
+// config and save into temporary file inside output folder.
+// Return empty string if there is no cover
+func (e Engine) prepareCover(ctx context.Context, song *SongInfo) string {
+	opts := e.config.Cover
+	if opts.Skip {
+		return ""
+	}
+	candidates := selectCovers(song.Info.Thumbnails, opts.Size)
+	if len(song.Cover) > 0 {
+		candidates = []string{song.Cover}
+	}
+	for _, cover := range candidates {
+		data, ext, err := loadCover(ctx, cover)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ""
+			}
+			continue
+		}
+		if opts.process() {
+			processed, err := processCover(data, opts)
+			if err != nil {
+				// unsupported format, keep it as is
+				log.Println(err)
+			} else {
+				data, ext = processed, "jpg"
+			}
+		}
+		thumbnail, err := makeTempPath(e.outputFolder, ext)
+		if err != nil {
+			return ""
+		}
+		if err := ioutil.WriteFile(thumbnail, data, 0644); err != nil {
+			_ = os.Remove(thumbnail)
+			return ""
+		}
+		return thumbnail
+	}
+	return ""
+}
+
+// selectCovers return urls of artworks in order they should be tried for
+// preferred size. Artworks with unknown size (0) are considered the biggest
+func selectCovers(artworks map[string]parsers.Artwork, size int) []string {
+	list := make([]parsers.Artwork, 0, len(artworks))
+	for _, a := range artworks {
+		if len(a.URL) > 0 {
+			list = append(list, a)
+		}
+	}
+	side := func(a parsers.Artwork) int {
+		if a.Size < 1 {
+			return int(^uint(0) >> 1)
+		}
+		return a.Size
+	}
+	// suitable artworks go first, the closest to size first, then smaller
+	// ones, the biggest first
+	less := func(a, b parsers.Artwork) bool {
+		sa, sb := side(a), side(b)
+		if size < 1 || (sa < size && sb < size) {
+			return sa > sb
+		}
+		if sa >= size && sb >= size {
+			return sa < sb
+		}
+		return sa >= size
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if side(list[i]) != side(list[j]) {
+			return less(list[i], list[j])
+		}
+		return list[i].Type < list[j].Type
+	})
+	urls := make([]string, 0, len(list))
+	for _, a := range list {
+		urls = append(urls, a.URL)
+	}
+	return urls
+}
+
+// loadCover read cover from url or local file. Return its data and
+// extension without dot
+func loadCover(ctx context.Context, cover string) ([]byte, string, error) {
+	var data []byte
+	imageURL, err := url.Parse(cover)
+	if err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+		data, err = utils.FetchContext(ctx, imageURL)
+	} else {
+		data, err = ioutil.ReadFile(cover)
+		imageURL = &url.URL{Path: cover}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) < 1 {
+		return nil, "", os.ErrNotExist
+	}
+	return data, strings.TrimPrefix(path.Ext(imageURL.Path), "."), nil
+}
+
+// processCover crop, shrink and re-encode cover into jpeg
+func processCover(data []byte, opts CoverOptions) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Square {
+		img = cropSquare(img)
+	}
+	if opts.MaxSide > 0 {
+		img = shrink(img, opts.MaxSide)
+	}
+	for {
+		out, fits, err := encodeJPEG(img, opts.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		side := longestSide(img) * 3 / 4
+		if fits || side < _minCoverSide {
+			return out, nil
+		}
+		// even the lowest quality is too big, try smaller image
+		img = shrink(img, side)
+	}
+}
+
+// encodeJPEG encode img with the highest quality, which fit into maxBytes.
+// If even the lowest quality doesn't fit - fits is false
+func encodeJPEG(img image.Image, maxBytes int) (data []byte, fits bool, err error) {
+	for quality := _maxJPEGQuality; quality >= _minJPEGQuality; quality -= 10 {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, false, err
+		}
+		data = buf.Bytes()
+		if maxBytes < 1 || len(data) <= maxBytes {
+			return data, true, nil
+		}
+	}
+	return data, false, nil
+}
+
+func longestSide(img image.Image) int {
+	b := img.Bounds()
+	if b.Dx() > b.Dy() {
+		return b.Dx()
+	}
+	return b.Dy()
+}
+
+// cropSquare cut the biggest centered square from img
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Pt(x, y), draw.Src)
+	return square
+}
+
+// shrink scale img down, so its longest side is maxSide. Every pixel of
+// result is an average of source pixels it covers. Smaller images are
+// returned as is
+func shrink(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	if longestSide(img) <= maxSide || maxSide < 1 {
+		return img
+	}
+	w, h := maxSide, b.Dy()*maxSide/b.Dx()
+	if b.Dy() > b.Dx() {
+		w, h = b.Dx()*maxSide/b.Dy(), maxSide
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := b.Min.Y + (y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := b.Min.X + (x+1)*b.Dx()/w
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+func TestSelectCovers(t *testing.T) {
+	artworks := map[string]parsers.Artwork{
+		"t300x300": {Type: "t300x300", URL: "300", Size: 300},
+		"large":    {Type: "large", URL: "100", Size: 100},
+		"t500x500": {Type: "t500x500", URL: "500", Size: 500},
+		"original": {Type: "original", URL: "original", Size: 0},
+		"empty":    {Type: "empty", Size: 1000},
+	}
+	tests := []struct {
+		name string
+		size int
+		want []string
+	}{
+		{"biggest", 0, []string{"original", "500", "300", "100"}},
+		{"exact", 300, []string{"300", "500", "original", "100"}},
+		{"between", 400, []string{"500", "original", "300", "100"}},
+		{"too big", 1000, []string{"original", "500", "300", "100"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectCovers(artworks, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectCovers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessCover(t *testing.T) {
+	// noise doesn't compress well, so size limit takes a few steps
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			img.Set(x, y, color.RGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     CoverOptions
+		width    int
+		height   int
+		maxBytes int
+	}{
+		{"re-encode", CoverOptions{MaxBytes: 1 << 30}, 800, 600, 1 << 30},
+		{"square", CoverOptions{Square: true}, 600, 600, 0},
+		{"max side", CoverOptions{MaxSide: 400}, 400, 300, 0},
+		{"square and max side", CoverOptions{Square: true, MaxSide: 200}, 200, 200, 0},
+		{"max bytes", CoverOptions{MaxBytes: 40 << 10}, 0, 0, 40 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := processCover(buf.Bytes(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("result isn't jpeg: %v", err)
+			}
+			if tt.width > 0 && (cfg.Width != tt.width || cfg.Height != tt.height) {
+				t.Errorf("size = %dx%d, want %dx%d", cfg.Width, cfg.Height, tt.width, tt.height)
+			}
+			if tt.maxBytes > 0 && len(data) > tt.maxBytes {
+				t.Errorf("len = %d, want at most %d", len(data), tt.maxBytes)
+			}
+		})
+	}
+
+	if _, err := processCover([]byte("not an image"), CoverOptions{Square: true}); err == nil {
+		t.Error("processCover() of invalid image: expected error")
+	}
+}
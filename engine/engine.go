@@ -1,29 +1,60 @@
 package engine
 
 import (
-	"fmt"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/camelva/erzo/loaders"
 	"github.com/camelva/erzo/parsers"
-	"github.com/camelva/erzo/utils"
-	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var _extractors = map[string]parsers.Extractor{}
 var _loaders = map[string]loaders.Loader{}
 
+// _registryMu guard _extractors and _loaders, so they can be registered
+// while engines are already working
+var _registryMu sync.RWMutex
+
 const (
-	_urlPattern = `((?:[a-z]{3,6}:\/\/)|(?:^|\s))` +
-		`((?:[a-zA-Z0-9\-]+\.)+[a-z]{2,13})` +
-		`([\.\?\=\&\%\/\w\-]*\b)`
+	_urlPattern = `(?i)((?:[a-z][a-z0-9+.\-]{1,9}://)?)` +
+		`((?:[\p{L}\p{N}\-]+\.)+\p{L}{2,13})` +
+		`((?::\d{1,5})?(?:[/?#][^\s<>"]*)?)`
+	// _urlTrailing is a punctuation and markdown, which more likely belongs
+	// to sentence than to url, when it's the last character. Closing
+	// brackets are trimmed too, but only unbalanced ones
+	_urlTrailing = `.,;:!?'"*>`
 )
 
+var _urlRE = regexp.MustCompile(_urlPattern)
+
+// _joinedURLsRE match separator between urls without spaces, like
+// "https://a.com/x,https://b.com/y"
+var _joinedURLsRE = regexp.MustCompile(`(?i)([,;])(https?://)`)
+
+// _knownTLDs are top-level domains, for which urls without scheme and path,
+// like "soundcloud.com", are recognized. Other bare hosts are more likely
+// to be a typo in sentence, like "thanks.ok"
+var _knownTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "info": true, "io": true,
+	"co": true, "me": true, "be": true, "fm": true, "tv": true,
+	"app": true, "link": true, "ly": true, "to": true, "gl": true,
+	"us": true, "uk": true, "de": true, "fr": true, "ru": true,
+	"ua": true, "рф": true,
+}
+
 type SongResult struct {
 	Path       string
 	Author     string
@@ -31,42 +62,124 @@ type SongResult struct {
 	Thumbnails map[string]parsers.Artwork
 	Duration   time.Duration
 	UploadDate time.Time
+	// Tracks are paths of every written file: Path itself, or files of
+	// chapters, when song is split. Path is the first of them
+	Tracks []string
 }
 
 type SongInfo struct {
-	Info     *parsers.ExtractorInfo
-	Metadata []string
-	URL      string
+	Info *parsers.ExtractorInfo
+	// Metadata is written into output file. It can be edited before Get,
+	// directly or with Override
+	Metadata loaders.Tags
+	// Cover is an url or local path of picture, embedded into output file.
+	// If empty - cover is chosen from Info.Thumbnails by Config.Cover
+	Cover string
+	URL   string
+	// Start and End limit downloaded part of song. End 0 means the end of
+	// song. They can be set directly or with Override
+	Start time.Duration
+	End   time.Duration
 
 	engine *Engine
+	// source is an url, from which song was extracted
+	source string
 }
 
 func AddExtractor(x parsers.Extractor) {
 	name := x.Name()
+	_registryMu.Lock()
 	_extractors[name] = x
+	_registryMu.Unlock()
 	//_extractors = append(_extractors, x)
 }
+
+// Extractors return copy of registered extractors
 func Extractors() map[string]parsers.Extractor {
-	return _extractors
+	_registryMu.RLock()
+	defer _registryMu.RUnlock()
+	xtrs := make(map[string]parsers.Extractor, len(_extractors))
+	for name, x := range _extractors {
+		xtrs[name] = x
+	}
+	return xtrs
 }
 
 func AddLoader(l loaders.Loader) {
 	name := l.Name()
+	_registryMu.Lock()
 	_loaders[name] = l
+	_registryMu.Unlock()
 	//_loaders = append(_loaders, l)
 }
+
+// Loaders return copy of registered loaders
 func Loaders() map[string]loaders.Loader {
-	return _loaders
+	_registryMu.RLock()
+	defer _registryMu.RUnlock()
+	ldrs := make(map[string]loaders.Loader, len(_loaders))
+	for name, l := range _loaders {
+		ldrs[name] = l
+	}
+	return ldrs
 }
 
 type Engine struct {
 	extractors   map[string]parsers.Extractor
 	loaders      map[string]loaders.Loader
 	outputFolder string
+	config       Config
+}
+
+// Config contain optional engine's settings. Zero value is ready to use
+type Config struct {
+	// Collection limit which songs are extracted from multi-track urls
+	Collection parsers.CollectionOptions
+	// Profile of output files. loaders.DefaultProfile is used if empty
+	Profile loaders.Profile
+	// Progress receive updates of every processed song. It's called
+	// synchronously, so it shouldn't block for long
+	Progress func(Progress)
+	// ExtractWorkers and DownloadWorkers limit number of songs, which are
+	// extracted and downloaded at once by batch processing. Defaults are
+	// 4 and 2 respectively
+	ExtractWorkers  int
+	DownloadWorkers int
+	// Archive of already downloaded songs, which are skipped with ErrArchived.
+	// Nil means every song is downloaded
+	Archive Archive
+	// Template of output file path, relative to output folder. Fields like
+	// {title} are replaced with song's info, see templateFields for the
+	// full list. DefaultTemplate is used if empty. Names are sanitized by
+	// SanitizeFilename, which isn't full NFC normalization: decomposed
+	// letters, which aren't in its table, are kept as is
+	Template string
+	// Collision define what to do if output file already exists
+	Collision Collision
+	// ASCIIFilenames make output file names transliterated into ascii
+	ASCIIFilenames bool
+	// Cover define how cover is chosen and processed before embedding
+	Cover CoverOptions
+	// Chapters define what to do with songs, which have chapters
+	Chapters ChapterMode
+	// ChapterTemplate is the same as Template, but for files of chapters.
+	// It also has {track} field, while {album} is song's title.
+	// DefaultChapterTemplate is used if empty
+	ChapterTemplate string
+	// Lyrics define how lyrics are fetched from captions
+	Lyrics LyricsOptions
+	// Sidecars define which files are written next to downloaded song
+	Sidecars SidecarOptions
+	// InfoProcessors change extracted info of every song before metadata is
+	// created from it, like ParseTitle. They are applied in given order
+	InfoProcessors []InfoProcessor
+	// MetadataRules are applied to every song right after extraction,
+	// in given order
+	MetadataRules []MetadataRule
 }
 
 // New return new instance of Engine
-func New(out string, truncate bool) *Engine {
+func New(out string, truncate bool, config Config) *Engine {
 	xtrs := Extractors()
 	ldrs := Loaders()
 	if (len(xtrs) < 1) || (len(ldrs) < 1) {
@@ -77,6 +190,7 @@ func New(out string, truncate bool) *Engine {
 		extractors:   xtrs,
 		loaders:      ldrs,
 		outputFolder: out,
+		config:       config,
 	}
 	if truncate {
 		e.Clean()
@@ -99,164 +213,516 @@ func (e Engine) Clean() {
 // ErrDownloadingError if fatal error occurred while downloading song
 // ErrUndefined any other errors
 func (e Engine) Process(s string) (*SongResult, error) {
-	song, err := e.GetInfo(s)
+	return e.ProcessContext(context.Background(), s)
+}
+
+// ProcessContext same as Process, but whole process will be aborted when ctx is done.
+// In this case ErrCancelled returned
+func (e Engine) ProcessContext(ctx context.Context, s string) (*SongResult, error) {
+	song, err := e.GetInfoContext(ctx, s)
 	if err != nil {
 		return nil, err
 	}
-	return song.Get()
+	return song.GetContext(ctx)
 }
 
 func (e Engine) GetInfo(s string) (*SongInfo, error) {
+	return e.GetInfoContext(context.Background(), s)
+}
+
+// GetInfoContext same as GetInfo, but extracting will be aborted when ctx is done
+func (e Engine) GetInfoContext(ctx context.Context, s string) (*SongInfo, error) {
 	u, ok := ExtractURL(s)
 	if !ok {
 		return nil, ErrNotURL{}
 	}
-	info, err := e.extractInfo(*u)
+	e.report(Progress{URL: u.String(), Stage: StageExtracting})
+	info, err := e.extractInfo(ctx, *u)
 	if err != nil {
 		return nil, err
 	}
+	return e.newSongInfo(info, u.String())
+}
 
+func (e Engine) newSongInfo(info *parsers.ExtractorInfo, source string) (*SongInfo, error) {
 	if len(info.Formats) < 1 {
 		return nil, ErrCantFetchInfo{}
 	}
 
-	return &SongInfo{
-		Info:   info,
-		URL:    info.Formats[0].Url,
-		engine: &e,
-	}, nil
+	for _, process := range e.config.InfoProcessors {
+		process(info)
+	}
+	song := &SongInfo{
+		Info:     info,
+		Metadata: createMetadata(info),
+		URL:      info.Formats[0].Url,
+		engine:   &e,
+		source:   source,
+	}
+	for _, rule := range e.config.MetadataRules {
+		rule(song)
+	}
+	return song, nil
 }
 
 func (s *SongInfo) Get() (*SongResult, error) {
-	s.Metadata = createMetadata(s.Info)
-	filePath, err := s.engine.downloadSong(s.Info, s.Metadata)
+	return s.GetContext(context.Background())
+}
+
+// GetContext same as Get, but downloading will be aborted when ctx is done.
+// Partially downloaded files are removed in this case.
+// If engine has archive and song is already in it - ErrArchived returned
+func (s *SongInfo) GetContext(ctx context.Context) (*SongResult, error) {
+	archive := s.engine.config.Archive
+	archived := archive != nil && len(s.Info.ID) > 0
+	if archived {
+		has, err := archive.Has(s.Info.Extractor, s.Info.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		if has {
+			return nil, ErrArchived{Extractor: s.Info.Extractor, ID: s.Info.ID}
+		}
+	}
+	paths, duration, err := s.engine.downloadSong(ctx, s)
 	if err != nil {
 		return nil, err
 	}
+	if archived {
+		if err := archive.Add(s.Info.Extractor, s.Info.ID); err != nil {
+			log.Println(err)
+		}
+	}
+	s.report(StageDone, loaders.Progress{})
 	return &SongResult{
-		Path:       filePath,
+		Path:       paths[0],
+		Tracks:     paths,
 		Author:     s.Info.Uploader,
 		Title:      s.Info.Title,
 		Thumbnails: s.Info.Thumbnails,
-		Duration:   s.Info.Duration,
+		Duration:   duration,
 		UploadDate: s.Info.Timestamp,
 	}, nil
 }
 
-func (e Engine) extractInfo(u url.URL) (*parsers.ExtractorInfo, error) {
+func (e Engine) extractInfo(ctx context.Context, u url.URL) (*parsers.ExtractorInfo, error) {
 	for _, xtr := range e.extractors {
 		if !xtr.Compatible(u) {
 			continue
 		}
-		info, err := xtr.Extract(u)
+		info, err := xtr.Extract(ctx, u)
 		if err != nil {
-			switch err.(type) {
-			case parsers.ErrFormatNotSupported:
-				return nil, ErrUnsupportedType{err.(parsers.ErrFormatNotSupported)}
-			case parsers.ErrCantContinue:
-				return nil, ErrDownloadingError{err.Error()}
-			default:
-				return nil, ErrUndefined{}
-			}
+			return nil, convertExtractorErr(ctx, err)
 		}
+		info.Extractor = xtr.Name()
 		return info, nil
 	}
 	return nil, ErrUnsupportedService{Service: u.Hostname()}
 }
 
-func (e Engine) downloadSong(info *parsers.ExtractorInfo, metadata []string) (string, error) {
-	if _, err := ioutil.ReadDir(e.outputFolder); err != nil {
-		// outputFolder don't exist. Creating it...
-		if err := os.Mkdir(e.outputFolder, 0700); err != nil {
-			// can't create outPutFolder. Going to save files in root directory
-			e.outputFolder = ""
+// convertExtractorErr convert errors returned by parsers into engine's ones
+func convertExtractorErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ErrCancelled{ctx.Err()}
+	}
+	switch err.(type) {
+	case parsers.ErrFormatNotSupported:
+		return ErrUnsupportedType{err.(parsers.ErrFormatNotSupported)}
+	case parsers.ErrCantContinue:
+		return ErrDownloadingError{err.Error()}
+	default:
+		return ErrUndefined{}
+	}
+}
+
+// downloadSong download song into output folder and return paths of
+// written files: a single one, or files of chapters if song is split, and
+// duration of downloaded part
+func (e Engine) downloadSong(ctx context.Context, song *SongInfo) ([]string, time.Duration, error) {
+	// info describe downloaded part of song
+	info, err := song.trimmedInfo()
+	if err != nil {
+		return nil, 0, err
+	}
+	start, end, _ := song.trimRange()
+	if err := os.MkdirAll(e.outputFolder, 0700); err != nil {
+		// can't create outPutFolder. Going to save files in root directory
+		e.outputFolder = ""
+	}
+	profile := e.profile()
+	thumbnail := e.prepareCover(ctx, song)
+	allLyrics := e.prepareLyrics(ctx, song)
+	lyrics := song.trimLyrics(allLyrics)
+	var splitter loaders.Splitter
+	if len(info.Chapters) > 1 && e.config.Chapters == ChaptersSplit {
+		if splitter = e.splitter(); splitter == nil {
+			log.Println("there is no loader, which can split songs. Chapters are written as markers")
 		}
 	}
-	outPath := makeFilePath(e.outputFolder, info.Permalink)
-	imageURL, err := url.Parse(info.Thumbnails["original"].URL)
-	var thumbnail string
-	if err == nil {
-		res, err := utils.Fetch(imageURL)
-		if err == nil {
-			thumbnail = path.Join(e.outputFolder, imageURL.Path)
-			if err := ioutil.WriteFile(thumbnail, res, 0644); err != nil {
-				thumbnail = ""
-			}
+	markers := e.config.Chapters != ChaptersIgnore && splitter == nil
+	tags := e.songTags(song, info, lyrics, markers)
+	// every file is written under unique temporary name and renamed only
+	// when it's complete, so concurrent downloads never see each other's
+	// partial files
+	var tempPath string
+	// cleanup removes everything we wrote if process was cancelled
+	cleanup := func() error {
+		if len(tempPath) > 0 {
+			_ = os.Remove(tempPath)
+		}
+		if len(thumbnail) > 0 {
+			_ = os.Remove(thumbnail)
 		}
+		return ErrCancelled{ctx.Err()}
 	}
 	var downloadingErr error
-	for _, format := range info.Formats {
+	for _, format := range orderFormats(info.Formats, profile) {
+		if ctx.Err() != nil {
+			return nil, 0, cleanup()
+		}
 		u, err := url.Parse(format.Url)
 		if err != nil {
 			// invalid url, try another
 			continue
 		}
-		for _, ldr := range e.loaders {
-			if !ldr.Compatible(format) {
+		formatProfile := profileFor(format, profile)
+		ext := outputExt(format, formatProfile)
+		outPath := path.Join(e.outputFolder, renderTemplate(e.config.Template, info, ext, e.config.ASCIIFilenames))
+		if e.config.Collision == CollisionSkip && splitter == nil {
+			if _, err := os.Stat(outPath); err == nil {
+				if len(thumbnail) > 0 {
+					_ = os.Remove(thumbnail)
+				}
+				return nil, 0, ErrFileExists{Path: outPath}
+			}
+		}
+		tempPath, err = makeTempPath(e.outputFolder, ext)
+		if err != nil {
+			downloadingErr = err
+			continue
+		}
+		job := loaders.Job{
+			URL:      u,
+			Format:   format,
+			Output:   tempPath,
+			Profile:  formatProfile,
+			Duration: song.Info.Duration,
+			Progress: func(p loaders.Progress) {
+				song.report(StageDownloading, p)
+			},
+			Start: start,
+			End:   end,
+		}
+		// part is an actually downloaded part of song, when loader keep
+		// more than requested
+		var part *SongInfo
+		job.Cut = func(start time.Duration, end time.Duration) {
+			p := *song
+			p.Start, p.End = start, end
+			part = &p
+		}
+		for _, ldr := range e.sortedLoaders() {
+			if !ldr.Compatible(job) {
 				// incompatible with loader, try another one
 				continue
 			}
-			if err := ldr.Get(u, outPath); err != nil {
+			part = nil
+			if err := ldr.Get(ctx, job); err != nil {
+				if ctx.Err() != nil {
+					return nil, 0, cleanup()
+				}
 				// save err
 				downloadingErr = err
 				continue
 			}
-			if err := ldr.UpdateTags(outPath, metadata); err != nil {
+			if part != nil {
+				// describe what was downloaded, so duration, chapters and
+				// lyrics match the file
+				if partInfo, err := part.trimmedInfo(); err == nil {
+					info, lyrics = partInfo, part.trimLyrics(allLyrics)
+					tags = e.songTags(part, info, lyrics, markers)
+				}
+			}
+			if splitter != nil {
+				paths, err := e.splitChapters(ctx, song, info, ldr, splitter, tempPath, ext, tags, thumbnail)
+				_ = os.Remove(tempPath)
+				if err != nil {
+					if len(thumbnail) > 0 {
+						_ = os.Remove(thumbnail)
+					}
+					return nil, 0, err
+				}
+				e.writeSidecars(paths[0], info, format, thumbnail, lyrics)
+				return paths, info.Duration, nil
+			}
+			song.report(StageTagging, loaders.Progress{})
+			if err := ldr.UpdateTags(ctx, tempPath, tags); err != nil {
 				log.Println(err)
 			}
 			if len(thumbnail) > 0 {
-				if err := ldr.AddThumbnail(outPath, thumbnail); err != nil {
+				song.report(StageEmbeddingCover, loaders.Progress{})
+				if err := ldr.AddThumbnail(ctx, tempPath, thumbnail); err != nil {
 					log.Println(err)
 				}
-				_ = os.Remove(thumbnail)
 			}
-			return outPath, nil
+			if ctx.Err() != nil {
+				return nil, 0, cleanup()
+			}
+			finalPath, err := reserveFile(outPath, e.config.Collision)
+			if err != nil {
+				_ = os.Remove(tempPath)
+				if len(thumbnail) > 0 {
+					_ = os.Remove(thumbnail)
+				}
+				if _, ok := err.(ErrFileExists); ok {
+					return nil, 0, err
+				}
+				return nil, 0, ErrDownloadingError{Reason: err.Error()}
+			}
+			if err := os.Rename(tempPath, finalPath); err != nil {
+				_ = os.Remove(tempPath)
+				if len(thumbnail) > 0 {
+					_ = os.Remove(thumbnail)
+				}
+				return nil, 0, ErrDownloadingError{Reason: err.Error()}
+			}
+			e.writeSidecars(finalPath, info, format, thumbnail, lyrics)
+			return []string{finalPath}, info.Duration, nil
 		}
+		_ = os.Remove(tempPath)
+	}
+	if len(thumbnail) > 0 {
+		_ = os.Remove(thumbnail)
 	}
 	if downloadingErr != nil {
-		return "", ErrDownloadingError{Reason: downloadingErr.Error()}
+		return nil, 0, ErrDownloadingError{Reason: downloadingErr.Error()}
 	}
-	return "", ErrUnsupportedProtocol{}
+	return nil, 0, ErrUnsupportedProtocol{}
 }
 
-func createMetadata(info *parsers.ExtractorInfo) []string {
-	metaMap := map[string]string{
-		"title": info.Title,
-		"album": info.Title,
-		//"genre":      info.Genre,
-		"artist":       info.Uploader,
-		"album_artist": info.Uploader,
-		"track":        strconv.Itoa(1),
-		"date":         strconv.Itoa(info.Timestamp.Year()),
+// songTags return tags of downloaded part of song, described by info.
+// Song's metadata is copied and stays untouched
+func (e Engine) songTags(song *SongInfo, info *parsers.ExtractorInfo, lyrics loaders.Lyrics, markers bool) loaders.Tags {
+	tags := song.Metadata
+	if e.config.Lyrics.Embed {
+		tags.Lyrics = lyrics
 	}
-	var metadata = make([]string, 0, len(metaMap))
-	for key, value := range metaMap {
-		line := fmt.Sprintf("%s=%s", key, value)
-		metadata = append(metadata, line)
+	if song.trimmed() {
+		// note source part of song, custom fields are copied to keep
+		// song's metadata untouched
+		tags.Custom = make(map[string]string, len(song.Metadata.Custom)+1)
+		for k, v := range song.Metadata.Custom {
+			tags.Custom[k] = v
+		}
+		tags.SetCustom("source_range", song.sourceRange())
+	}
+	if markers && len(info.Chapters) > 1 {
+		tags.Chapters = chapterMarkers(info)
+	}
+	return tags
+}
+
+// createMetadata build tags from extracted info
+func createMetadata(info *parsers.ExtractorInfo) loaders.Tags {
+	artist := info.Uploader
+	if len(info.Artist) > 0 {
+		artist = info.Artist
+	}
+	date := strconv.Itoa(info.Timestamp.Year())
+	if !info.ReleaseDate.IsZero() {
+		date = info.ReleaseDate.Format("2006-01-02")
+	}
+	return loaders.Tags{
+		Title:        info.Title,
+		Artists:      append([]string{artist}, info.Featured...),
+		Album:        info.Title,
+		AlbumArtists: []string{artist},
+		Genre:        info.Genre,
+		Track:        1,
+		Date:         date,
+		Comment:      info.Description,
+		Publisher:    info.Label,
+		ISRC:         info.ISRC,
+		URL:          info.WebPageURL,
 	}
-	return metadata
 }
 
-func makeFilePath(folder string, title string) string {
-	fileName := fmt.Sprintf("%s.mp3", title)
-	outPath := path.Join(folder, fileName)
-	//if _, err := ioutil.ReadFile(outPath); err == nil {
-	//	title = fmt.Sprintf("%s-copy", title)
-	//	return makeFilePath(folder, title)
-	//}
-	return outPath
+// sortedLoaders return engine's loaders sorted by name, so they are always
+// tried in the same order
+func (e Engine) sortedLoaders() []loaders.Loader {
+	names := make([]string, 0, len(e.loaders))
+	for name := range e.loaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ldrs := make([]loaders.Loader, 0, len(names))
+	for _, name := range names {
+		ldrs = append(ldrs, e.loaders[name])
+	}
+	return ldrs
+}
+
+// profile return output profile from engine's config or default one
+func (e Engine) profile() loaders.Profile {
+	if len(e.config.Profile.Codec) < 1 {
+		return loaders.DefaultProfile
+	}
+	return e.config.Profile
+}
+
+// profileFor return profile for certain source format. If source is already
+// encoded with requested codec and its bitrate isn't higher than requested -
+// stream is copied as is, without quality loss and transcoding
+func profileFor(format parsers.Format, profile loaders.Profile) loaders.Profile {
+	if profile.Codec == loaders.CodecCopy || format.Codec != profile.Codec {
+		return profile
+	}
+	if !profile.VBR && profile.Bitrate > 0 && format.Bitrate > profile.Bitrate {
+		// user asked for smaller file
+		return profile
+	}
+	return loaders.Profile{Codec: loaders.CodecCopy}
+}
+
+// orderFormats move formats, which can be copied without transcoding into
+// requested profile, to the top. Relative order of formats is kept
+func orderFormats(formats parsers.Formats, profile loaders.Profile) parsers.Formats {
+	ordered := make(parsers.Formats, len(formats))
+	copy(ordered, formats)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		iCopy := profileFor(ordered[i], profile).Codec == loaders.CodecCopy
+		jCopy := profileFor(ordered[j], profile).Codec == loaders.CodecCopy
+		return iCopy && !jCopy
+	})
+	return ordered
+}
+
+// outputExt return extension of output file for given source format.
+// When stream is copied as is - container depends on source codec
+func outputExt(format parsers.Format, profile loaders.Profile) string {
+	if profile.Codec != loaders.CodecCopy {
+		return profile.Ext()
+	}
+	if ext := loaders.ExtForCodec(format.Codec); len(ext) > 0 {
+		return ext
+	}
+	// matroska can hold any codec
+	return "mka"
+}
+
+// makeTempPath create empty file with unique name and given extension
+// inside folder and return its path. Extension is kept, because loaders
+// choose container by it. Unlike ioutil.TempFile, which create files with
+// 0600, file get default permissions (0666 without umask), because it's
+// renamed into output file
+func makeTempPath(folder string, ext string) (string, error) {
+	if len(folder) < 1 {
+		folder = "."
+	}
+	suffix := ""
+	if len(ext) > 0 {
+		suffix = "." + ext
+	}
+	for try := 0; ; try++ {
+		random := make([]byte, 6)
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		name := filepath.Join(folder, ".erzo-"+hex.EncodeToString(random)+suffix)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := f.Close(); err != nil {
+			_ = os.Remove(name)
+			return "", err
+		}
+		return name, nil
+	}
 }
 
 // ExtractURL trying to extract url from message
 func ExtractURL(message string) (u *url.URL, ok bool) {
-	re := regexp.MustCompile(_urlPattern)
-	rawURL := re.FindString(message)
-	if len(rawURL) < 1 {
+	urls := ExtractURLs(message)
+	if len(urls) < 1 {
 		return nil, false
 	}
-	link, err := url.Parse(rawURL)
-	if err != nil {
-		return nil, false
+	return urls[0], true
+}
+
+// ExtractURLs return every url found in message, without duplicates and in
+// order of appearance. Urls without scheme get https one. They are
+// recognized only when followed by path or port, or when their top-level
+// domain is a common one, so words like "thanks.ok" aren't taken as urls
+func ExtractURLs(message string) []*url.URL {
+	var urls []*url.URL
+	seen := make(map[string]bool)
+	message = _joinedURLsRE.ReplaceAllString(message, "$1 $2")
+	for _, m := range _urlRE.FindAllStringSubmatchIndex(message, -1) {
+		start, end := m[0], m[1]
+		hasScheme := m[3] > m[2]
+		hasPath := m[7] > m[6]
+		host := message[m[4]:m[5]]
+		if m[5] < len(message) {
+			next, _ := utf8.DecodeRuneInString(message[m[5]:])
+			// domain is cut from longer word, like "file.mp" of "file.mp3"
+			if isWordRune(next) {
+				continue
+			}
+		}
+		if !hasScheme && !hasPath && !_knownTLDs[strings.ToLower(host[strings.LastIndexByte(host, '.')+1:])] {
+			continue
+		}
+		if start > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(message[:start])
+			// part of email or some other word, not an url
+			if prev == '@' || prev == '.' || prev == '/' || (!hasScheme && isWordRune(prev)) {
+				continue
+			}
+		}
+		if end < len(message) && message[end] == '@' {
+			// local part of email, like "john.doe@mail.com"
+			continue
+		}
+		rawURL := trimURL(message[start:end])
+		if !hasScheme {
+			rawURL = "https://" + rawURL
+		}
+		link, err := url.Parse(rawURL)
+		if err != nil || len(link.Hostname()) < 1 {
+			continue
+		}
+		if seen[link.String()] {
+			continue
+		}
+		seen[link.String()] = true
+		urls = append(urls, link)
 	}
-	return link, true
+	return urls
+}
+
+// trimURL remove trailing punctuation and unbalanced closing brackets,
+// like in "(see example.com/page)."
+func trimURL(s string) string {
+	for len(s) > 0 {
+		last := s[len(s)-1]
+		switch {
+		case strings.IndexByte(_urlTrailing, last) >= 0:
+			s = s[:len(s)-1]
+		case last == ')' && strings.Count(s, "(") < strings.Count(s, ")"):
+			s = s[:len(s)-1]
+		case last == ']' && strings.Count(s, "[") < strings.Count(s, "]"):
+			s = s[:len(s)-1]
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
 }
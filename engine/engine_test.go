@@ -0,0 +1,947 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/engine"
+	"github.com/camelva/erzo/loaders"
+	_ "github.com/camelva/erzo/loaders/hls"
+	"github.com/camelva/erzo/loaders/id3"
+	_ "github.com/camelva/erzo/loaders/progressive"
+	"github.com/camelva/erzo/parsers"
+)
+
+// fixtureAudio is served as mp3 file. Loaders don't parse audio, so any
+// bytes will do
+var fixtureAudio = bytes.Repeat([]byte("\xff\xfbfake mp3 frame;"), 4096)
+
+var fixtureCover = []byte("\xff\xd8\xff\xe0fake jpeg")
+
+var fixtureTracklist = "Tracklist:\n00:00 Intro\n1. Artist - Song Two (02:30)\n[7:05] Outro\n\nSee you!"
+
+var fixtureCaptions = `<transcript><text start="0.5" dur="2">First line</text>` +
+	`<text start="75.25" dur="3">Second line</text></transcript>`
+
+// fixtureSegments is a number of one-minute segments in hls fixture
+const fixtureSegments = 10
+
+// fixtureExtractor handle urls like https://fixtures.test/song/N and point
+// them to local server. Songs with id starting with "stream" are served
+// as hls streams, and ones starting with "stall" never finish downloading
+type fixtureExtractor struct {
+	name   string
+	server string
+}
+
+func (x fixtureExtractor) Name() string {
+	return x.name
+}
+
+func (x fixtureExtractor) Compatible(u url.URL) bool {
+	return u.Hostname() == "fixtures.test"
+}
+
+func (x fixtureExtractor) Extract(ctx context.Context, u url.URL) (*parsers.ExtractorInfo, error) {
+	id := path.Base(u.Path)
+	description := "Fixture song " + id
+	if id == "mix" {
+		description = fixtureTracklist
+	}
+	format := parsers.Format{
+		Url:      x.server + "/audio/" + id + ".mp3",
+		Ext:      "mp3",
+		Type:     "mpeg",
+		Protocol: "progressive",
+		Codec:    "mp3",
+		Bitrate:  128,
+	}
+	if strings.HasPrefix(id, "stream") {
+		format.Url, format.Protocol = x.server+"/hls/"+id+".m3u8", "hls"
+	}
+	if strings.HasPrefix(id, "stall") {
+		format.Url = x.server + "/stall/" + id + ".mp3"
+	}
+	return &parsers.ExtractorInfo{
+		ID:          id,
+		Description: description,
+		Duration:    10 * time.Minute,
+		Chapters:    parsers.ParseChapters(description, 10*time.Minute),
+		Permalink:   "song-" + id,
+		WebPageURL:  "https://fixtures.test/song/" + id,
+		Title:       "Song " + id,
+		Uploader:    "Fixtures",
+		Genre:       "Electronic",
+		Label:       "Fixture Records",
+		ISRC:        "QZFIX2000001",
+		Thumbnails: map[string]parsers.Artwork{
+			"original": {Type: "original", URL: x.server + "/cover/" + id + ".jpg"},
+		},
+		Captions: []parsers.Caption{
+			{Language: "en", URL: x.server + "/captions/" + id + ".xml"},
+		},
+		Formats: parsers.Formats{format},
+	}, nil
+}
+
+var (
+	_fixtureOnce   sync.Once
+	_fixtureServer *httptest.Server
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if _fixtureServer != nil {
+		_fixtureServer.Close()
+	}
+	os.Exit(code)
+}
+
+// setupFixture start server of fixture audio, covers and captions and
+// register extractor for it. Both happen once per test binary. Every call
+// create new output folder, which is removed by cleanup
+func setupFixture(t *testing.T) (srv *httptest.Server, dir string, cleanup func()) {
+	_fixtureOnce.Do(func() {
+		_fixtureServer = httptest.NewServer(http.HandlerFunc(serveFixture))
+		engine.AddExtractor(fixtureExtractor{name: "fixtures", server: _fixtureServer.URL})
+	})
+	dir, err := ioutil.TempDir("", "erzo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return _fixtureServer, dir, func() { _ = os.RemoveAll(dir) }
+}
+
+// serveFixture serve fixture audio, hls streams, covers and captions
+func serveFixture(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/audio/"):
+		http.ServeContent(w, r, "song.mp3", time.Time{}, bytes.NewReader(fixtureAudio))
+	case strings.HasPrefix(r.URL.Path, "/stall/"):
+		// send half of audio and wait until client gives up
+		w.Header().Set("Content-Length", strconv.Itoa(len(fixtureAudio)))
+		_, _ = w.Write(fixtureAudio[:len(fixtureAudio)/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	case strings.HasPrefix(r.URL.Path, "/hls/") && path.Ext(r.URL.Path) == ".m3u8":
+		var list strings.Builder
+		list.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:60\n")
+		for i := 0; i < fixtureSegments; i++ {
+			fmt.Fprintf(&list, "#EXTINF:60,\nsegment-%d.mp3\n", i)
+		}
+		list.WriteString("#EXT-X-ENDLIST\n")
+		_, _ = w.Write([]byte(list.String()))
+	case strings.HasPrefix(r.URL.Path, "/hls/segment-"):
+		var i int
+		if _, err := fmt.Sscanf(path.Base(r.URL.Path), "segment-%d.mp3", &i); err != nil || i >= fixtureSegments {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(fixtureSegment(i))
+	case strings.HasPrefix(r.URL.Path, "/cover/"):
+		_, _ = w.Write(fixtureCover)
+	case strings.HasPrefix(r.URL.Path, "/captions/"):
+		_, _ = w.Write([]byte(fixtureCaptions))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEngine_ProcessParallel(t *testing.T) {
+	srv, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	const (
+		downloads = 16
+		// every song is downloaded twice, at the same time
+		songs = downloads / 2
+	)
+	var wg sync.WaitGroup
+	errs := make([]error, downloads)
+	results := make([]*engine.SongResult, downloads)
+	for i := 0; i < downloads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// registration must be safe while other engines are working
+			engine.AddExtractor(fixtureExtractor{name: "fixtures", server: srv.URL})
+			e := engine.New(dir, false, engine.Config{})
+			results[i], errs[i] = e.Process(fmt.Sprintf("https://fixtures.test/song/%d", i%songs))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < downloads; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Process() #%d error = %v", i, errs[i])
+		}
+		id := i % songs
+		want := filepath.Join(dir, fmt.Sprintf("song-%d.mp3", id))
+		if results[i].Path != want {
+			t.Errorf("Process() #%d path = %s, want %s", i, results[i].Path, want)
+		}
+		tag, err := id3.ReadFile(want)
+		if err != nil {
+			t.Fatalf("can't read tag of %s: %v", want, err)
+		}
+		if title := tag.Text("TIT2"); title != fmt.Sprintf("Song %d", id) {
+			t.Errorf("%s has title %q", want, title)
+		}
+		if _, ok := tag.Get("APIC"); !ok {
+			t.Errorf("%s has no cover", want)
+		}
+		data, err := ioutil.ReadFile(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(data, fixtureAudio) {
+			t.Errorf("%s has corrupted audio", want)
+		}
+	}
+
+	// only final files should be left, without any temporary ones
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != songs {
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("output folder contain %d files, want %d: %v", len(files), songs, names)
+	}
+}
+
+func TestEngine_ProcessBatch(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	messages := []string{
+		"https://fixtures.test/song/1",
+		"not a url",
+		"look at this https://fixtures.test/song/2",
+		"https://unknown.test/song/3",
+		"https://fixtures.test/song/3",
+	}
+	e := engine.New(dir, false, engine.Config{ExtractWorkers: 2, DownloadWorkers: 1})
+	results := e.ProcessBatch(messages)
+	if len(results) != len(messages) {
+		t.Fatalf("ProcessBatch() returned %d results, want %d", len(results), len(messages))
+	}
+	for i, res := range results {
+		if res.Index != i || res.Message != messages[i] {
+			t.Errorf("result #%d belongs to #%d %q", i, res.Index, res.Message)
+		}
+	}
+	for _, i := range []int{0, 2, 4} {
+		if results[i].Err != nil || results[i].Song == nil {
+			t.Errorf("result #%d error = %v", i, results[i].Err)
+		}
+	}
+	if _, ok := results[1].Err.(engine.ErrNotURL); !ok {
+		t.Errorf("result #1 error = %v, want ErrNotURL", results[1].Err)
+	}
+	if _, ok := results[3].Err.(engine.ErrUnsupportedService); !ok {
+		t.Errorf("result #3 error = %v, want ErrUnsupportedService", results[3].Err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, res := range e.ProcessBatchContext(ctx, messages) {
+		if res.Err == nil {
+			t.Errorf("cancelled batch result #%d has no error", res.Index)
+		}
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{"no urls", "just some text", nil},
+		{"scheme only", "http:// and its all", nil},
+		{"single", "https://soundcloud.com/whenzz/4-u", []string{"https://soundcloud.com/whenzz/4-u"}},
+		{
+			"several with duplicates",
+			"https://youtu.be/fsjANtamXl4 and https://soundcloud.com/a/b, again https://youtu.be/fsjANtamXl4",
+			[]string{"https://youtu.be/fsjANtamXl4", "https://soundcloud.com/a/b"},
+		},
+		{
+			"trailing punctuation",
+			"listen to https://soundcloud.com/a/b! Or https://youtu.be/x?t=1.",
+			[]string{"https://soundcloud.com/a/b", "https://youtu.be/x?t=1"},
+		},
+		{
+			"parentheses",
+			"(see https://en.wikipedia.org/wiki/Song_(music)) and (soundcloud.com/a/b)",
+			[]string{"https://en.wikipedia.org/wiki/Song_(music)", "https://soundcloud.com/a/b"},
+		},
+		{
+			"without scheme",
+			"try soundcloud.com/a/b or www.youtube.com/watch?v=x9xJ4r6Vhnk&feature=youtu.be",
+			[]string{"https://soundcloud.com/a/b", "https://www.youtube.com/watch?v=x9xJ4r6Vhnk&feature=youtu.be"},
+		},
+		{
+			"unicode path",
+			"https://soundcloud.com/артист/песня-№1, cool",
+			[]string{"https://soundcloud.com/%D0%B0%D1%80%D1%82%D0%B8%D1%81%D1%82/%D0%BF%D0%B5%D1%81%D0%BD%D1%8F-%E2%84%961"},
+		},
+		{"email", "write to john.doe@mail.com", nil},
+		{"port", "http://localhost.test:8080/song", []string{"http://localhost.test:8080/song"}},
+		{"file name", "download file.mp3 please", nil},
+		{"file name with scheme", "see https://file.mp3", nil},
+		{"unknown tld without path", "thanks.ok bye", nil},
+		{"unknown tld with path", "thanks.ok/bye", []string{"https://thanks.ok/bye"}},
+		{"unknown tld with port", "host.test:8080", []string{"https://host.test:8080"}},
+		{"unknown tld with scheme", "http://thanks.ok", []string{"http://thanks.ok"}},
+		{"known tld at the end", "try youtu.be", []string{"https://youtu.be"}},
+		{"known tld in uppercase", "SOUNDCLOUD.COM, please", []string{"https://SOUNDCLOUD.COM"}},
+		{"markdown bold", "**https://soundcloud.com/a/b**", []string{"https://soundcloud.com/a/b"}},
+		{"markdown link", "[song](https://soundcloud.com/a/b)", []string{"https://soundcloud.com/a/b"}},
+		{"angle brackets", "<https://soundcloud.com/a/b>", []string{"https://soundcloud.com/a/b"}},
+		{
+			"joined by comma",
+			"https://a.com/x,https://b.com/y;HTTP://c.com/z",
+			[]string{"https://a.com/x", "https://b.com/y", "http://c.com/z"},
+		},
+		{"comma inside url", "https://a.com/x,y", []string{"https://a.com/x,y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.ExtractURLs(tt.message)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ExtractURLs() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].String() != tt.want[i] {
+					t.Errorf("ExtractURLs()[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_Archive(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+	archivePath := filepath.Join(dir, "archive.txt")
+
+	archive, err := engine.NewFileArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := engine.New(filepath.Join(dir, "out"), false, engine.Config{Archive: archive})
+	if _, err := e.Process("https://fixtures.test/song/1"); err != nil {
+		t.Fatalf("first Process() error = %v", err)
+	}
+	if _, err := e.Process("https://fixtures.test/song/1"); err != (engine.ErrArchived{Extractor: "fixtures", ID: "1"}) {
+		t.Fatalf("second Process() error = %v, want ErrArchived", err)
+	}
+
+	data, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "fixtures 1\n" {
+		t.Errorf("archive file = %q", data)
+	}
+	reloaded, err := engine.NewFileArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := reloaded.Has("Fixtures", "1"); !has {
+		t.Errorf("reloaded archive doesn't have song")
+	}
+	if has, _ := reloaded.Has("fixtures", "2"); has {
+		t.Errorf("reloaded archive has song, which wasn't downloaded")
+	}
+}
+
+func TestEngine_Collision(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	config := engine.Config{Template: "{uploader}/{title}.{ext}", Collision: engine.CollisionNumber}
+	e := engine.New(dir, false, config)
+	for _, want := range []string{"Song 1.mp3", "Song 1 (1).mp3", "Song 1 (2).mp3"} {
+		res, err := e.Process("https://fixtures.test/song/1")
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+		if want = filepath.Join(dir, "Fixtures", want); res.Path != want {
+			t.Errorf("Process() path = %s, want %s", res.Path, want)
+		}
+	}
+
+	config.Collision = engine.CollisionSkip
+	e = engine.New(dir, false, config)
+	if _, err := e.Process("https://fixtures.test/song/1"); err == nil {
+		t.Errorf("Process() didn't skip existing file")
+	} else if _, ok := err.(engine.ErrFileExists); !ok {
+		t.Errorf("Process() error = %v, want ErrFileExists", err)
+	}
+	if _, err := e.Process("https://fixtures.test/song/2"); err != nil {
+		t.Errorf("Process() error = %v", err)
+	}
+}
+
+func TestEngine_Progress(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	tests := []struct {
+		name   string
+		url    string
+		stages []engine.Stage
+		// last is the final downloading update
+		last loaders.Progress
+	}{
+		{
+			"bytes",
+			"https://fixtures.test/song/1",
+			[]engine.Stage{engine.StageExtracting, engine.StageDownloading, engine.StageTagging,
+				engine.StageEmbeddingCover, engine.StageDone},
+			loaders.Progress{Bytes: int64(len(fixtureAudio)), TotalBytes: int64(len(fixtureAudio)), Duration: 10 * time.Minute},
+		},
+		{
+			"time",
+			"https://fixtures.test/song/stream",
+			[]engine.Stage{engine.StageExtracting, engine.StageDownloading, engine.StageTagging,
+				engine.StageEmbeddingCover, engine.StageDone},
+			loaders.Progress{Bytes: int64(fixtureSegments * len(fixtureSegment(0))), Time: 10 * time.Minute, Duration: 10 * time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates []engine.Progress
+			e := engine.New(dir, false, engine.Config{
+				Progress: func(p engine.Progress) { updates = append(updates, p) },
+			})
+			if _, err := e.Process(tt.url); err != nil {
+				t.Fatal(err)
+			}
+			var stages []engine.Stage
+			var last loaders.Progress
+			for i, p := range updates {
+				if p.URL != tt.url || (p.Stage != engine.StageExtracting && p.Song == nil) {
+					t.Errorf("update %d = %+v", i, p)
+				}
+				if p.Stage == engine.StageDownloading {
+					if p.Bytes < last.Bytes || p.Time < last.Time {
+						t.Errorf("progress goes back: %+v after %+v", p.Progress, last)
+					}
+					last = p.Progress
+				}
+				if len(stages) < 1 || stages[len(stages)-1] != p.Stage {
+					stages = append(stages, p.Stage)
+				}
+			}
+			if !reflect.DeepEqual(stages, tt.stages) {
+				t.Errorf("stages = %v, want %v", stages, tt.stages)
+			}
+			if last != tt.last {
+				t.Errorf("last progress = %+v, want %+v", last, tt.last)
+			}
+		})
+	}
+}
+
+func TestEngine_Cancel(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		url  string
+		// stage, which cancel processing
+		stage engine.Stage
+	}{
+		{"while downloading", "https://fixtures.test/song/stall", engine.StageDownloading},
+		{"after downloading", "https://fixtures.test/song/1", engine.StageTagging},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			e := engine.New(dir, false, engine.Config{
+				Progress: func(p engine.Progress) {
+					if p.Stage == tt.stage {
+						cancel()
+					}
+				},
+			})
+			res, err := e.ProcessContext(ctx, tt.url)
+			if _, ok := err.(engine.ErrCancelled); !ok {
+				t.Fatalf("ProcessContext() = %+v, %v, want ErrCancelled", res, err)
+			}
+			files, _ := ioutil.ReadDir(dir)
+			for _, f := range files {
+				t.Errorf("file %s is left", f.Name())
+			}
+		})
+	}
+}
+
+func TestEngine_Sidecars(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{Sidecars: engine.SidecarOptions{Info: true, ProcessedCover: true}})
+	res, err := e.Process("https://fixtures.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := strings.TrimSuffix(res.Path, ".mp3")
+	cover, err := ioutil.ReadFile(base + ".jpg")
+	if err != nil || !bytes.Equal(cover, fixtureCover) {
+		t.Errorf("cover sidecar = %q, %v", cover, err)
+	}
+	data, err := ioutil.ReadFile(base + ".info.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info struct {
+		parsers.ExtractorInfo
+		Format parsers.Format
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "1" || info.Extractor != "fixtures" || info.Format.Codec != "mp3" {
+		t.Errorf("info sidecar = %s", data)
+	}
+
+	e = engine.New(dir, false, engine.Config{Sidecars: engine.SidecarOptions{ProcessedCover: true, CoverName: "cover.jpg"}})
+	if _, err := e.Process("https://fixtures.test/song/2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cover.jpg")); err != nil {
+		t.Errorf("named cover sidecar: %v", err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".erzo-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files are left: %v", leftovers)
+	}
+
+	// written files get default permissions, like any other new file
+	reference := filepath.Join(dir, "reference")
+	f, err := os.OpenFile(reference, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	want, err := os.Stat(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{res.Path, base + ".jpg", base + ".info.json", filepath.Join(dir, "cover.jpg")} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != want.Mode().Perm() {
+			t.Errorf("%s has mode %v, want %v", filepath.Base(name), stat.Mode().Perm(), want.Mode().Perm())
+		}
+	}
+}
+
+func TestEngine_Lyrics(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{
+		Lyrics:   engine.LyricsOptions{Languages: []string{"en"}, Embed: true},
+		Sidecars: engine.SidecarOptions{Lyrics: []string{"lrc", "srt"}},
+	})
+	res, err := e.Process("https://fixtures.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uslt, ok := tag.Get("USLT"); !ok || !bytes.HasSuffix(uslt, []byte("First line\nSecond line")) {
+		t.Errorf("USLT = %q", uslt)
+	}
+	if _, ok := tag.Get("SYLT"); !ok {
+		t.Errorf("SYLT isn't written")
+	}
+
+	base := strings.TrimSuffix(res.Path, ".mp3")
+	tests := []struct {
+		ext  string
+		want string
+	}{
+		{"lrc", "[la:en]\n[00:00.50]First line\n[01:15.25]Second line\n"},
+		{"srt", "1\n00:00:00,500 --> 00:00:02,500\nFirst line\n\n" +
+			"2\n00:01:15,250 --> 00:01:18,250\nSecond line\n\n"},
+	}
+	for _, tt := range tests {
+		got, err := ioutil.ReadFile(base + "." + tt.ext)
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != tt.want {
+			t.Errorf("%s sidecar = %q, want %q", tt.ext, got, tt.want)
+		}
+	}
+}
+
+// splitterLoader cut files by copying them and remember requested parts.
+// It's never used for downloading
+type splitterLoader struct {
+	mu   sync.Mutex
+	cuts [][2]time.Duration
+}
+
+func (l *splitterLoader) Name() string                           { return "splitter" }
+func (l *splitterLoader) Bin() string                            { return "" }
+func (l *splitterLoader) Compatible(job loaders.Job) bool        { return false }
+func (l *splitterLoader) Get(context.Context, loaders.Job) error { return fmt.Errorf("not supported") }
+func (l *splitterLoader) UpdateTags(context.Context, string, loaders.Tags) error {
+	return fmt.Errorf("not supported")
+}
+func (l *splitterLoader) AddThumbnail(context.Context, string, string) error {
+	return fmt.Errorf("not supported")
+}
+
+func (l *splitterLoader) Split(ctx context.Context, filename string, output string, start time.Duration, end time.Duration) error {
+	l.mu.Lock()
+	l.cuts = append(l.cuts, [2]time.Duration{start, end})
+	l.mu.Unlock()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(output, data, 0644)
+}
+
+func TestEngine_Chapters(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{Chapters: engine.ChaptersMarkers})
+	res, err := e.Process("https://fixtures.test/song/mix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chapters []string
+	for _, f := range tag.Frames {
+		if f.ID == "CHAP" {
+			chapters = append(chapters, string(f.Data))
+		}
+	}
+	if len(chapters) != 3 || !strings.Contains(chapters[1], "Artist - Song Two") {
+		t.Errorf("CHAP frames = %q", chapters)
+	}
+	if _, ok := tag.Get("CTOC"); !ok {
+		t.Errorf("CTOC isn't written")
+	}
+
+	engine.AddLoader(&splitterLoader{})
+	e = engine.New(dir, false, engine.Config{Chapters: engine.ChaptersSplit})
+	res, err = e.Process("https://fixtures.test/song/mix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"01 - Intro.mp3", "02 - Song Two.mp3", "03 - Outro.mp3"}
+	if len(res.Tracks) != len(want) || res.Path != res.Tracks[0] {
+		t.Fatalf("Process() tracks = %v, path = %s", res.Tracks, res.Path)
+	}
+	for i, name := range want {
+		if p := filepath.Join(dir, "Song mix", name); res.Tracks[i] != p {
+			t.Errorf("track %d = %s, want %s", i, res.Tracks[i], p)
+		}
+	}
+	tag, err = id3.ReadFile(res.Tracks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := [...]string{tag.Text("TIT2"), tag.Text("TPE1"), tag.Text("TALB"), tag.Text("TRCK")}; got !=
+		[...]string{"Song Two", "Artist", "Song mix", "2"} {
+		t.Errorf("chapter tags = %q", got)
+	}
+	if _, ok := tag.Get("CHAP"); ok {
+		t.Errorf("chapter file has chapter markers")
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".erzo-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files are left: %v", leftovers)
+	}
+}
+
+func TestEngine_ChaptersTrimmed(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	splitter := &splitterLoader{}
+	engine.AddLoader(splitter)
+	e := engine.New(dir, false, engine.Config{Chapters: engine.ChaptersSplit})
+	song, err := e.GetInfo("https://fixtures.test/song/mix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song.Override(engine.Overrides{Start: 2 * time.Minute, End: 8 * time.Minute})
+	res, err := song.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tracks) != 3 {
+		t.Fatalf("Get() tracks = %v", res.Tracks)
+	}
+	// chapters are cut relative to downloaded part, which starts at 02:00
+	want := [][2]time.Duration{
+		{0, 30 * time.Second},
+		{30 * time.Second, 5*time.Minute + 5*time.Second},
+		{5*time.Minute + 5*time.Second, 6 * time.Minute},
+	}
+	if !reflect.DeepEqual(splitter.cuts, want) {
+		t.Errorf("Split() calls = %v, want %v", splitter.cuts, want)
+	}
+}
+
+func TestSongInfo_Trim(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{})
+	song, err := e.GetInfo("https://fixtures.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song.Override(engine.Overrides{Start: time.Minute, End: 2 * time.Minute})
+	res, err := song.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Duration != time.Minute {
+		t.Errorf("Duration = %v, want %v", res.Duration, time.Minute)
+	}
+	// fixture song lasts 10 minutes
+	want := fixtureAudio[len(fixtureAudio)/10 : len(fixtureAudio)/5]
+	data, err := ioutil.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, want) || len(data)-len(tag.Bytes()) != len(want) {
+		t.Errorf("trimmed audio has %d bytes, want %d", len(data)-len(tag.Bytes()), len(want))
+	}
+	if txxx, _ := tag.Get("TXXX"); !bytes.Contains(txxx, []byte("source_range\x0000:01:00-00:02:00")) {
+		t.Errorf("TXXX = %q, want source range", txxx)
+	}
+	if len(song.Metadata.Custom) > 0 {
+		t.Errorf("song's metadata is changed: %v", song.Metadata.Custom)
+	}
+
+	song.Override(engine.Overrides{Start: 11 * time.Minute})
+	if _, err := song.Get(); err == nil {
+		t.Errorf("Get() with range outside of song: expected error")
+	} else if _, ok := err.(engine.ErrInvalidRange); !ok {
+		t.Errorf("Get() error = %v, want ErrInvalidRange", err)
+	}
+}
+
+// fixtureSegment return audio of i-th segment of hls fixture
+func fixtureSegment(i int) []byte {
+	size := len(fixtureAudio) / fixtureSegments
+	return fixtureAudio[i*size : (i+1)*size]
+}
+
+func TestSongInfo_TrimStream(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{
+		Lyrics:   engine.LyricsOptions{Languages: []string{"en"}},
+		Sidecars: engine.SidecarOptions{Lyrics: []string{"lrc"}},
+	})
+	song, err := e.GetInfo("https://fixtures.test/song/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hls loader keep whole segments, so 01:00-03:00 is downloaded
+	song.Override(engine.Overrides{Start: 70 * time.Second, End: 130 * time.Second})
+	res, err := song.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Duration != 2*time.Minute {
+		t.Errorf("Duration = %v, want %v", res.Duration, 2*time.Minute)
+	}
+	data, err := ioutil.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]byte{}, fixtureSegment(1)...), fixtureSegment(2)...); !bytes.HasSuffix(data, want) {
+		t.Errorf("stream audio isn't made of segments 1 and 2")
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txxx, _ := tag.Get("TXXX"); !bytes.Contains(txxx, []byte("source_range\x0000:01:00-00:03:00")) {
+		t.Errorf("TXXX = %q, want downloaded range", txxx)
+	}
+	// caption at 01:15.25 is shifted by real start of file
+	lrc, err := ioutil.ReadFile(strings.TrimSuffix(res.Path, ".mp3") + ".lrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[la:en]\n[00:15.25]Second line\n"; string(lrc) != want {
+		t.Errorf("lrc sidecar = %q, want %q", lrc, want)
+	}
+}
+
+func TestSongInfo_EditMetadata(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{})
+	song, err := e.GetInfo("https://fixtures.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Metadata.Title != "Song 1" {
+		t.Errorf("GetInfo() metadata title = %q, want %q", song.Metadata.Title, "Song 1")
+	}
+	song.Metadata.Title = "Fixed title"
+	song.Metadata.Artists = []string{"First", "Second"}
+	song.Metadata.SetCustom("mood", "calm")
+
+	res, err := song.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tag.Text("TIT2"); got != "Fixed title" {
+		t.Errorf("title = %q, want %q", got, "Fixed title")
+	}
+	if got := tag.Text("TPE1"); got != "First/Second" {
+		t.Errorf("artists = %q, want %q", got, "First/Second")
+	}
+	if _, ok := tag.Get("TXXX"); !ok {
+		t.Errorf("custom field isn't written")
+	}
+}
+
+func TestEngine_Metadata(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+
+	e := engine.New(dir, false, engine.Config{})
+	res, err := e.Process("https://fixtures.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		frame string
+		want  string
+	}{
+		{"title", "TIT2", "Song 1"},
+		{"artist", "TPE1", "Fixtures"},
+		{"genre", "TCON", "Electronic"},
+		{"isrc", "TSRC", "QZFIX2000001"},
+		{"label", "TPUB", "Fixture Records"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tag.Text(tt.frame); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.frame, got, tt.want)
+			}
+		})
+	}
+	if woas, _ := tag.Get("WOAS"); string(woas) != "https://fixtures.test/song/1" {
+		t.Errorf("source url = %q, want %q", woas, "https://fixtures.test/song/1")
+	}
+	if comm, ok := tag.Get("COMM"); !ok || !bytes.HasSuffix(comm, []byte("eng\x00Fixture song 1")) {
+		t.Errorf("comment = %q, want %q", comm, "Fixture song 1")
+	}
+}
+
+func TestSongInfo_Override(t *testing.T) {
+	_, dir, cleanup := setupFixture(t)
+	defer cleanup()
+	cover := filepath.Join(dir, "cover.png")
+	coverData := []byte("\x89PNG\r\n\x1a\nfake png")
+	if err := ioutil.WriteFile(cover, coverData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := engine.New(filepath.Join(dir, "out"), false, engine.Config{
+		MetadataRules: []engine.MetadataRule{
+			engine.OverrideRule(engine.Overrides{Title: "Band - Track (Live)", Track: 3}),
+			engine.SplitArtistTitle("Fixtures"),
+			engine.SplitArtistTitle("YouTube"),
+		},
+	})
+	song, err := e.GetInfo("https://fixtures.test/song/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := loaders.Tags{
+		Title:        "Track (Live)",
+		Artists:      []string{"Band"},
+		Album:        "Track (Live)",
+		AlbumArtists: []string{"Band"},
+		Track:        3,
+	}
+	got := song.Metadata
+	if got.Title != want.Title || got.Album != want.Album || got.Track != want.Track ||
+		strings.Join(got.Artists, ",") != "Band" || strings.Join(got.AlbumArtists, ",") != "Band" {
+		t.Errorf("rules result = %+v, want %+v", got, want)
+	}
+
+	song.Override(engine.Overrides{Album: "Live at Home", Cover: cover})
+	res, err := song.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := id3.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if album := tag.Text("TALB"); album != "Live at Home" {
+		t.Errorf("album = %q, want %q", album, "Live at Home")
+	}
+	picture, ok := tag.Get("APIC")
+	if !ok || !bytes.HasSuffix(picture, coverData) {
+		t.Errorf("cover isn't replaced")
+	}
+	if _, err := os.Stat(cover); err != nil {
+		t.Errorf("local cover is removed: %v", err)
+	}
+}
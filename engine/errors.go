@@ -3,6 +3,7 @@ package engine
 import (
 	"fmt"
 	"github.com/camelva/erzo/parsers"
+	"time"
 )
 
 type ErrNotURL struct{}
@@ -17,6 +18,29 @@ func (ErrUndefined) Error() string {
 	return "undefined error"
 }
 
+// ErrCancelled returned when context was cancelled or its deadline exceeded
+type ErrCancelled struct {
+	Err error
+}
+
+func (e ErrCancelled) Error() string {
+	return fmt.Sprintf("process cancelled: %v", e.Err)
+}
+
+func (e ErrCancelled) Unwrap() error {
+	return e.Err
+}
+
+// ErrArchived returned when song is already in download archive
+type ErrArchived struct {
+	Extractor string
+	ID        string
+}
+
+func (e ErrArchived) Error() string {
+	return fmt.Sprintf("song %s %s is already downloaded", e.Extractor, e.ID)
+}
+
 // parsers errors
 type ErrUnsupportedService struct {
 	Service string
@@ -43,6 +67,27 @@ func (ErrUnsupportedProtocol) Error() string {
 	return "current loaders don't work with this protocol"
 }
 
+// ErrFileExists returned when output file already exists and
+// CollisionSkip is used
+type ErrFileExists struct {
+	Path string
+}
+
+func (e ErrFileExists) Error() string {
+	return fmt.Sprintf("file %s already exists", e.Path)
+}
+
+// ErrInvalidRange returned when song's Start and End don't define any
+// part of it
+type ErrInvalidRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (e ErrInvalidRange) Error() string {
+	return fmt.Sprintf("invalid range of song: %v - %v", e.Start, e.End)
+}
+
 type ErrDownloadingError struct {
 	Reason string
 }
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+// DefaultTemplate is used for output file names when Config.Template is empty
+const DefaultTemplate = "{permalink}.{ext}"
+
+// _maxNameLength is a maximum length of single path element in bytes.
+// Most filesystems allow 255, some space is left for numbered suffixes
+const _maxNameLength = 200
+
+var _templateFieldRE = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Collision define what to do, when output file already exists
+type Collision int
+
+const (
+	// CollisionOverwrite replace existing file
+	CollisionOverwrite Collision = iota
+	// CollisionSkip keep existing file, ErrFileExists is returned
+	CollisionSkip
+	// CollisionNumber add numbered suffix, like "name (1).mp3"
+	CollisionNumber
+)
+
+// templateFields return values of template fields for given song
+func templateFields(info *parsers.ExtractorInfo, ext string) map[string]string {
+	fields := map[string]string{
+		"id":        info.ID,
+		"title":     info.Title,
+		"permalink": info.Permalink,
+		"uploader":  info.Uploader,
+		"artist":    info.Uploader,
+		"genre":     info.Genre,
+		"label":     info.Label,
+		"album":     info.Title,
+		"extractor": info.Extractor,
+		"ext":       ext,
+	}
+	if len(info.Artist) > 0 {
+		fields["artist"] = info.Artist
+	}
+	if !info.Timestamp.IsZero() {
+		fields["date"] = info.Timestamp.Format("2006-01-02")
+		fields["year"] = info.Timestamp.Format("2006")
+	}
+	return fields
+}
+
+// renderTemplate build relative file path from template. Every field's
+// value is sanitized, so it can't add path elements by itself, while "/"
+// from template separate directories. Unknown fields are kept as is.
+// Extension is added, if template doesn't have one. If ascii is true -
+// path is transliterated into ascii characters
+func renderTemplate(tmpl string, info *parsers.ExtractorInfo, ext string, ascii bool) string {
+	if len(tmpl) < 1 {
+		tmpl = DefaultTemplate
+	}
+	return renderFields(tmpl, templateFields(info, ext), info, ext, ascii)
+}
+
+// renderFields is the same as renderTemplate, but with prepared fields
+func renderFields(tmpl string, fields map[string]string, info *parsers.ExtractorInfo, ext string, ascii bool) string {
+	if !strings.Contains(tmpl, "{ext}") {
+		tmpl += ".{ext}"
+	}
+	rendered := _templateFieldRE.ReplaceAllStringFunc(tmpl, func(field string) string {
+		value, ok := fields[field[1:len(field)-1]]
+		if !ok {
+			return field
+		}
+		return SanitizeFilename(value, ascii)
+	})
+
+	elements := strings.Split(rendered, "/")
+	clean := make([]string, 0, len(elements))
+	for i, el := range elements {
+		isFile := i == len(elements)-1
+		if isFile {
+			// extension is sanitized apart from name, otherwise its dot
+			// is trimmed as leading one, when name is empty
+			name := SanitizeFilename(strings.TrimSuffix(el, "."+ext), ascii)
+			if len(name) < 1 {
+				// every field of file name was empty
+				name = fallbackName(info, ascii)
+			}
+			if strings.HasSuffix(el, "."+ext) {
+				name += "." + ext
+			}
+			el = limitFileName(name, ext)
+		} else {
+			el = limitLength(SanitizeFilename(el, ascii), _maxNameLength)
+		}
+		if len(el) < 1 || el == "." || el == ".." {
+			// skip empty directories
+			continue
+		}
+		clean = append(clean, el)
+	}
+	return path.Join(clean...)
+}
+
+// fallbackName return name for songs without anything usable in template
+func fallbackName(info *parsers.ExtractorInfo, ascii bool) string {
+	for _, name := range []string{info.Permalink, info.ID} {
+		if name = SanitizeFilename(name, ascii); len(name) > 0 {
+			return limitLength(name, _maxNameLength)
+		}
+	}
+	return "song"
+}
+
+// limitFileName shorten file name to _maxNameLength, keeping its extension
+func limitFileName(name string, ext string) string {
+	if len(name) <= _maxNameLength {
+		return name
+	}
+	suffix := "." + ext
+	base := strings.TrimSuffix(name, suffix)
+	return limitLength(base, _maxNameLength-len(suffix)) + suffix
+}
+
+// limitLength cut s to n bytes without breaking utf-8 characters
+func limitLength(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.TrimRight(s[:n], ". ")
+}
+
+// reserveFile create empty file at outPath according to collision strategy
+// and return its final path. Downloaded file is renamed into it, so no
+// concurrent download can take the same name
+func reserveFile(outPath string, collision Collision) (string, error) {
+	if err := os.MkdirAll(path.Dir(outPath), 0700); err != nil {
+		return "", err
+	}
+	if collision == CollisionOverwrite {
+		return outPath, nil
+	}
+	ext := path.Ext(outPath)
+	base := strings.TrimSuffix(outPath, ext)
+	candidate := outPath
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(candidate, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			return candidate, f.Close()
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		if collision == CollisionSkip {
+			return "", ErrFileExists{Path: outPath}
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
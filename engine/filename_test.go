@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	info := &parsers.ExtractorInfo{
+		ID:        "x9xJ4r6Vhnk",
+		Title:     "AC/DC: Back in Black?",
+		Permalink: "back-in-black",
+		Uploader:  "AC/DC",
+		Extractor: "YouTube",
+		Timestamp: time.Date(1980, 7, 25, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name string
+		tmpl string
+		want string
+	}{
+		{"default", "", "back-in-black.mp3"},
+		{"fields", "{artist} - {title} [{id}].{ext}", "AC_DC - AC_DC_ Back in Black_ [x9xJ4r6Vhnk].mp3"},
+		{"subdirectories", "{extractor}/{year}/{permalink}.{ext}", "YouTube/1980/back-in-black.mp3"},
+		{"without extension", "{date} {permalink}", "1980-07-25 back-in-black.mp3"},
+		{"unknown field", "{permalink} {bitrate}.{ext}", "back-in-black {bitrate}.mp3"},
+		{"traversal", "../{permalink}.{ext}", "back-in-black.mp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderTemplate(tt.tmpl, info, "mp3", false); got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	empty := &parsers.ExtractorInfo{ID: "42"}
+	if got := renderTemplate("{uploader}/{title}.{ext}", empty, "opus", false); got != "42.opus" {
+		t.Errorf("renderTemplate() with empty fields = %q, want %q", got, "42.opus")
+	}
+
+	dotted := &parsers.ExtractorInfo{ID: "7", Title: ".ethereal - ASTRAL", Uploader: "..."}
+	if got := renderTemplate("{uploader}/{title}.{ext}", dotted, "mp3", false); got != "ethereal - ASTRAL.mp3" {
+		t.Errorf("renderTemplate() with leading dots = %q, want %q", got, "ethereal - ASTRAL.mp3")
+	}
+
+	long := &parsers.ExtractorInfo{Title: strings.Repeat("ж", 300)}
+	got := renderTemplate("{title}.{ext}", long, "flac", false)
+	if len(got) > _maxNameLength || !strings.HasSuffix(got, "ж.flac") {
+		t.Errorf("renderTemplate() with long title = %q (%d bytes)", got, len(got))
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		ascii bool
+		want  string
+	}{
+		{"separators", `AC/DC\Live`, false, "AC_DC_Live"},
+		{"reserved chars", `What? "Yes": <no>|*`, false, "What_ _Yes__ _no___"},
+		{"control chars", "New\nLine\tTab\x00", false, "New Line Tab"},
+		{"trailing dots and spaces", "  Wait... ", false, "Wait"},
+		{"leading dot", ".ethereal - ASTRAL (ft. OSIAS)", false, "ethereal - ASTRAL (ft. OSIAS)"},
+		{"leading dots and spaces", " .. .hidden.mp3", false, "hidden.mp3"},
+		{"only dots", "...", false, ""},
+		{"reserved name", "con", false, "_con"},
+		{"reserved name with extension", "LPT1.mp3", false, "_LPT1.mp3"},
+		{"not reserved", "Console", false, "Console"},
+		{"combining marks", "Beyonce\u0301 - Pe\u0301ne\u0301lope", false, "Beyoncé - Pénélope"},
+		{"combining marks cyrillic", "И\u0306ошкар-Ола", false, "Йошкар-Ола"},
+		{"unknown composition kept", "Q\u0301 and a\u0304", false, "Q\u0301 and a\u0304"},
+		// hangul syllable "한" and greek "ά" are composed by NFC, but not here
+		{"decomposed hangul kept", "\u1112\u1161\u11ab", false, "\u1112\u1161\u11ab"},
+		{"decomposed greek kept", "\u03b1\u0301", false, "\u03b1\u0301"},
+		{"unicode kept", "ÆSTRAL - reasons", false, "ÆSTRAL - reasons"},
+		{"ascii latin", "Beyoncé – Crème brûlée", true, "Beyonce - Creme brulee"},
+		{"ascii specials", "ÆSTRAL – Straße", true, "AESTRAL - Strasse"},
+		{"ascii cyrillic", "Ёлка - Привет, Щука", true, "Yolka - Privet, Shchuka"},
+		{"ascii decomposed", "И\u0306ошкар-Ола", true, "Yoshkar-Ola"},
+		{"ascii unknown", "日本 song", true, "song"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFilename(tt.in, tt.ascii); got != tt.want {
+				t.Errorf("SanitizeFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+func TestProfileFor(t *testing.T) {
+	mp3 := parsers.Format{Codec: "mp3", Bitrate: 128}
+	tests := []struct {
+		name    string
+		format  parsers.Format
+		profile loaders.Profile
+		want    loaders.Profile
+		ext     string
+	}{
+		{"codec match", mp3, loaders.DefaultProfile, loaders.Profile{Codec: loaders.CodecCopy}, "mp3"},
+		{"bigger source", parsers.Format{Codec: "mp3", Bitrate: 320}, loaders.DefaultProfile, loaders.DefaultProfile, "mp3"},
+		{"bigger source with vbr", parsers.Format{Codec: "mp3", Bitrate: 320},
+			loaders.Profile{Codec: loaders.CodecMP3, VBR: true}, loaders.Profile{Codec: loaders.CodecCopy}, "mp3"},
+		{"codec mismatch", parsers.Format{Codec: "opus", Bitrate: 160}, loaders.DefaultProfile, loaders.DefaultProfile, "mp3"},
+		{"unknown codec", parsers.Format{Bitrate: 128}, loaders.DefaultProfile, loaders.DefaultProfile, "mp3"},
+		{"aac match", parsers.Format{Codec: "aac", Bitrate: 256}, loaders.Profile{Codec: loaders.CodecAAC},
+			loaders.Profile{Codec: loaders.CodecCopy}, "m4a"},
+		{"transcode to flac", mp3, loaders.Profile{Codec: loaders.CodecFLAC}, loaders.Profile{Codec: loaders.CodecFLAC}, "flac"},
+		{"copy opus", parsers.Format{Codec: "opus"}, loaders.Profile{Codec: loaders.CodecCopy},
+			loaders.Profile{Codec: loaders.CodecCopy}, "opus"},
+		{"copy vorbis", parsers.Format{Codec: "vorbis"}, loaders.Profile{Codec: loaders.CodecCopy},
+			loaders.Profile{Codec: loaders.CodecCopy}, "ogg"},
+		{"copy unknown codec", parsers.Format{}, loaders.Profile{Codec: loaders.CodecCopy},
+			loaders.Profile{Codec: loaders.CodecCopy}, "mka"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := profileFor(tt.format, tt.profile)
+			if got != tt.want {
+				t.Errorf("profileFor() = %+v, want %+v", got, tt.want)
+			}
+			if ext := outputExt(tt.format, got); ext != tt.ext {
+				t.Errorf("outputExt() = %q, want %q", ext, tt.ext)
+			}
+		})
+	}
+}
+
+func TestOrderFormats(t *testing.T) {
+	formats := parsers.Formats{
+		{Url: "opus", Codec: "opus", Bitrate: 160},
+		{Url: "aac", Codec: "aac", Bitrate: 256},
+		{Url: "mp3-320", Codec: "mp3", Bitrate: 320},
+		{Url: "mp3-128", Codec: "mp3", Bitrate: 128},
+		{Url: "mp3-96", Codec: "mp3", Bitrate: 96},
+	}
+	tests := []struct {
+		name    string
+		profile loaders.Profile
+		want    []string
+	}{
+		{"copyable first", loaders.DefaultProfile, []string{"mp3-128", "mp3-96", "opus", "aac", "mp3-320"}},
+		{"aac", loaders.Profile{Codec: loaders.CodecAAC}, []string{"aac", "opus", "mp3-320", "mp3-128", "mp3-96"}},
+		{"everything is copyable", loaders.Profile{Codec: loaders.CodecCopy}, []string{"opus", "aac", "mp3-320", "mp3-128", "mp3-96"}},
+		{"nothing is copyable", loaders.Profile{Codec: loaders.CodecFLAC}, []string{"opus", "aac", "mp3-320", "mp3-128", "mp3-96"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range orderFormats(formats, tt.profile) {
+				got = append(got, f.Url)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderFormats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if formats[0].Url != "opus" {
+		t.Errorf("orderFormats() changed original formats")
+	}
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"encoding/xml"
+	"html"
+	"log"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+	"github.com/camelva/erzo/utils"
+)
+
+// LyricsOptions define how lyrics are fetched from song's captions
+type LyricsOptions struct {
+	// Languages are preferred languages of captions in order, like "en".
+	// If there is none of them - the first non auto-generated track is used
+	Languages []string
+	// AutoGenerated allow speech recognized captions, when there are no
+	// better ones
+	AutoGenerated bool
+	// Embed write lyrics into output file
+	Embed bool
+}
+
+// prepareLyrics return lyrics of song. Lyrics set in song's metadata are
+// preferred, otherwise they are fetched from captions, if they are needed
+// for embedding or sidecars. Return empty lyrics if there are none
+func (e Engine) prepareLyrics(ctx context.Context, song *SongInfo) loaders.Lyrics {
+	if len(song.Metadata.Lyrics.Lines) > 0 {
+		return song.Metadata.Lyrics
+	}
+	opts := e.config.Lyrics
+	if !opts.Embed && len(e.config.Sidecars.Lyrics) < 1 {
+		return loaders.Lyrics{}
+	}
+	caption, ok := selectCaption(song.Info.Captions, opts.Languages, opts.AutoGenerated)
+	if !ok {
+		return loaders.Lyrics{}
+	}
+	u, err := url.Parse(caption.URL)
+	if err != nil {
+		log.Println(err)
+		return loaders.Lyrics{}
+	}
+	data, err := utils.FetchContext(ctx, u)
+	if err != nil {
+		log.Println(err)
+		return loaders.Lyrics{}
+	}
+	lines, err := parseTimedText(data)
+	if err != nil {
+		log.Println(err)
+		return loaders.Lyrics{}
+	}
+	return loaders.Lyrics{Language: caption.Language, Lines: lines}
+}
+
+// selectCaption choose caption track: the first of preferred languages,
+// then any non auto-generated one. Auto-generated tracks are used only if
+// auto is true, after ones written by people
+func selectCaption(captions []parsers.Caption, languages []string, auto bool) (parsers.Caption, bool) {
+	passes := []bool{false}
+	if auto {
+		passes = append(passes, true)
+	}
+	for _, generated := range passes {
+		for _, lang := range languages {
+			for _, c := range captions {
+				if c.AutoGenerated == generated && matchLanguage(c.Language, lang) {
+					return c, true
+				}
+			}
+		}
+	}
+	for _, generated := range passes {
+		for _, c := range captions {
+			if c.AutoGenerated == generated {
+				return c, true
+			}
+		}
+	}
+	return parsers.Caption{}, false
+}
+
+// matchLanguage report whether code, like "en-GB", is of language, like
+// "en" or "en-GB"
+func matchLanguage(code string, lang string) bool {
+	code, lang = strings.ToLower(code), strings.ToLower(lang)
+	return code == lang || strings.HasPrefix(code, lang+"-")
+}
+
+// timedText is a captions track. Legacy format has <text> elements with
+// timings in seconds, format 3 has <p> elements with timings in
+// milliseconds and words in <s> elements
+type timedText struct {
+	Texts []struct {
+		Start float64 `xml:"start,attr"`
+		Dur   float64 `xml:"dur,attr"`
+		Text  string  `xml:",chardata"`
+	} `xml:"text"`
+	Paragraphs []struct {
+		T     int64  `xml:"t,attr"`
+		D     int64  `xml:"d,attr"`
+		Text  string `xml:",chardata"`
+		Words []struct {
+			Text string `xml:",chardata"`
+		} `xml:"s"`
+	} `xml:"body>p"`
+}
+
+// parseTimedText convert captions track into lyrics lines. Empty lines and
+// music notes around lines are removed
+func parseTimedText(data []byte) ([]loaders.LyricLine, error) {
+	var track timedText
+	if err := xml.Unmarshal(data, &track); err != nil {
+		return nil, err
+	}
+	var lines []loaders.LyricLine
+	add := func(start time.Duration, duration time.Duration, text string) {
+		// legacy format escape entities twice
+		text = strings.TrimSpace(strings.Trim(html.UnescapeString(text), " \n♪"))
+		if len(text) > 0 {
+			lines = append(lines, loaders.LyricLine{Start: start, Duration: duration, Text: text})
+		}
+	}
+	for _, t := range track.Texts {
+		add(seconds(t.Start), seconds(t.Dur), t.Text)
+	}
+	for _, p := range track.Paragraphs {
+		text := p.Text
+		for _, w := range p.Words {
+			text += w.Text
+		}
+		add(time.Duration(p.T)*time.Millisecond, time.Duration(p.D)*time.Millisecond, text)
+	}
+	return lines, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s*1000)) * time.Millisecond
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+func TestSelectCaption(t *testing.T) {
+	captions := []parsers.Caption{
+		{Language: "en", URL: "en-auto", AutoGenerated: true},
+		{Language: "de", URL: "de"},
+		{Language: "pt-BR", URL: "pt-BR"},
+		{Language: "ja", URL: "ja-auto", AutoGenerated: true},
+	}
+	tests := []struct {
+		name      string
+		languages []string
+		auto      bool
+		want      string
+	}{
+		{"preferred", []string{"pt", "de"}, false, "pt-BR"},
+		{"manual before auto", []string{"en", "de"}, true, "de"},
+		{"auto fallback", []string{"ja"}, true, "ja-auto"},
+		{"no auto", []string{"ja"}, false, "de"},
+		{"any", nil, false, "de"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selectCaption(captions, tt.languages, tt.auto)
+			if !ok || got.URL != tt.want {
+				t.Errorf("selectCaption() = %q, %v, want %q", got.URL, ok, tt.want)
+			}
+		})
+	}
+	if _, ok := selectCaption(captions[:1], nil, false); ok {
+		t.Errorf("selectCaption() chose auto-generated track")
+	}
+}
+
+func TestParseTimedText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []loaders.LyricLine
+	}{
+		{
+			"legacy",
+			`<?xml version="1.0" encoding="utf-8" ?><transcript>` +
+				`<text start="1.2" dur="2.5">I&amp;#39;m here</text>` +
+				`<text start="3.7" dur="1">♪</text>` +
+				`<text start="4.7" dur="1.05">♪ Again &amp;amp; again ♪</text></transcript>`,
+			[]loaders.LyricLine{
+				{Start: 1200 * time.Millisecond, Duration: 2500 * time.Millisecond, Text: "I'm here"},
+				{Start: 4700 * time.Millisecond, Duration: 1050 * time.Millisecond, Text: "Again & again"},
+			},
+		},
+		{
+			"format 3",
+			`<?xml version="1.0" encoding="utf-8" ?><timedtext format="3"><body>` +
+				`<p t="500" d="2000">First line</p>` +
+				`<p t="2500" d="1500"><s>split</s><s t="300"> words</s></p>` +
+				`<p t="4000" d="10">` + "\n" + `</p></body></timedtext>`,
+			[]loaders.LyricLine{
+				{Start: 500 * time.Millisecond, Duration: 2 * time.Second, Text: "First line"},
+				{Start: 2500 * time.Millisecond, Duration: 1500 * time.Millisecond, Text: "split words"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimedText([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTimedText() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
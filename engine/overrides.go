@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"strings"
+	"time"
+)
+
+// Overrides replace song's metadata. Empty fields keep extracted values
+type Overrides struct {
+	Title   string
+	Artists []string
+	Album   string
+	// Cover is an url or local path of picture
+	Cover string
+	Track int
+	// Start and End download only part of song, see SongInfo.Start
+	Start time.Duration
+	End   time.Duration
+}
+
+// Override replace song's metadata, cover and downloaded part before
+// downloading
+func (s *SongInfo) Override(o Overrides) {
+	if len(o.Title) > 0 {
+		if s.Metadata.Album == s.Metadata.Title {
+			// album of single is named after song
+			s.Metadata.Album = o.Title
+		}
+		s.Metadata.Title = o.Title
+	}
+	if len(o.Artists) > 0 {
+		if equalStrings(s.Metadata.AlbumArtists, s.Metadata.Artists) {
+			s.Metadata.AlbumArtists = o.Artists
+		}
+		s.Metadata.Artists = o.Artists
+	}
+	if len(o.Album) > 0 {
+		s.Metadata.Album = o.Album
+	}
+	if len(o.Cover) > 0 {
+		s.Cover = o.Cover
+	}
+	if o.Track > 0 {
+		s.Metadata.Track = o.Track
+	}
+	if o.Start > 0 {
+		s.Start = o.Start
+	}
+	if o.End > 0 {
+		s.End = o.End
+	}
+}
+
+// MetadataRule change song's metadata right after extraction, before
+// caller get SongInfo
+type MetadataRule func(song *SongInfo)
+
+// OverrideRule apply the same overrides to every song
+func OverrideRule(o Overrides) MetadataRule {
+	return func(song *SongInfo) {
+		song.Override(o)
+	}
+}
+
+// SplitArtistTitle return rule, which split titles like "Artist - Title"
+// into artist and title. It's applied only to songs of given extractors,
+// or to every song if there are none. Unlike ParseTitle, featured artists
+// and noise are kept in title
+func SplitArtistTitle(extractors ...string) MetadataRule {
+	return func(song *SongInfo) {
+		if len(extractors) > 0 && !containsFold(extractors, song.Info.Extractor) {
+			return
+		}
+		artist, title, ok := splitArtistTitle(song.Metadata.Title)
+		if !ok {
+			return
+		}
+		song.Override(Overrides{Title: title, Artists: []string{artist}})
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, el := range list {
+		if strings.EqualFold(el, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"github.com/camelva/erzo/loaders"
+)
+
+// Stage of song processing
+type Stage int
+
+const (
+	StageExtracting Stage = iota
+	StageDownloading
+	StageSplitting
+	StageTagging
+	StageEmbeddingCover
+	StageDone
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageExtracting:
+		return "extracting"
+	case StageDownloading:
+		return "downloading"
+	case StageSplitting:
+		return "splitting"
+	case StageTagging:
+		return "tagging"
+	case StageEmbeddingCover:
+		return "embedding cover"
+	case StageDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// Progress is an update of song processing. Bytes and time fields are set
+// only at StageDownloading
+type Progress struct {
+	// URL which is processed. For collection's songs it's collection's url
+	URL string
+	// Song which is processed. It's nil at StageExtracting, so use URL to
+	// distinguish concurrent processes at this stage
+	Song  *SongInfo
+	Stage Stage
+	loaders.Progress
+}
+
+// report send progress to callback from engine's config, if there is one
+func (e Engine) report(p Progress) {
+	if e.config.Progress == nil {
+		return
+	}
+	e.config.Progress(p)
+}
+
+// report send stage of song processing to engine's progress callback
+func (s *SongInfo) report(stage Stage, p loaders.Progress) {
+	s.engine.report(Progress{
+		URL:      s.source,
+		Song:     s,
+		Stage:    stage,
+		Progress: p,
+	})
+}
@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// _reservedChars aren't allowed in file names on windows and some network
+// filesystems. Path separators are replaced too, so name can't create
+// directories by itself
+const _reservedChars = `/\<>:"|?*`
+
+// _reservedNames are device names, reserved by windows regardless of extension
+var _reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// _compositions list pairs of base letter and its precomposed form for
+// every combining mark. It's not full unicode table, but it covers latin
+// and cyrillic letters, which appear in titles in decomposed form
+var _compositions = map[rune]string{
+	'\u0300': "AÀEÈIÌOÒUÙaàeèiìoòuù",
+	'\u0301': "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćNŃnńSŚsśZŹzźLĹlĺRŔrŕ",
+	'\u0302': "AÂEÊIÎOÔUÛaâeêiîoôuû",
+	'\u0303': "AÃNÑOÕaãnñoõ",
+	'\u0306': "AĂaăGĞgğИЙийУЎуў",
+	'\u0307': "ZŻzżEĖeėIİ",
+	'\u0308': "AÄEËIÏOÖUÜaäeëiïoöuüyÿЕЁеёІЇії",
+	'\u030a': "AÅaåUŮuů",
+	'\u030b': "OŐoőUŰuű",
+	'\u030c': "CČcčDĎdďEĚeěNŇnňRŘrřSŠsšTŤtťZŽzž",
+	'\u0327': "CÇcçSŞsşTŢtţ",
+	'\u0328': "AĄaąEĘeę",
+}
+
+// _composed map base letter and combining mark into precomposed letter,
+// _decomposed map precomposed letter back into base one
+var _composed, _decomposed = buildCompositions()
+
+// _cyrillic is a transliteration of russian, ukrainian and belarusian letters
+var _cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e",
+	'ё': "yo", 'є': "ye", 'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "u", 'ф': "f", 'х': "kh",
+	'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// _specials is a transliteration of letters and punctuation, which can't be
+// decomposed into ascii letter and combining mark
+var _specials = map[rune]string{
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe", 'Ø': "O", 'ø': "o",
+	'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'Þ': "Th", 'þ': "th", 'ı': "i",
+	'‘': "'", '’': "'", '‚': "'", '“': `"`, '”': `"`, '„': `"`, '«': `"`, '»': `"`,
+	'–': "-", '—': "-", '…': "...", '№': "No", '×': "x",
+}
+
+func buildCompositions() (map[[2]rune]rune, map[rune]rune) {
+	composed := make(map[[2]rune]rune)
+	decomposed := make(map[rune]rune)
+	for mark, pairs := range _compositions {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			composed[[2]rune{runes[i], mark}] = runes[i+1]
+			decomposed[runes[i+1]] = runes[i]
+		}
+	}
+	return composed, decomposed
+}
+
+// SanitizeFilename make name safe for common filesystems: reserved
+// characters and path separators are replaced with "_", control characters
+// removed, leading dots, trailing dots and spaces trimmed, so name is never
+// hidden, and windows device names prefixed with "_". Latin and cyrillic
+// letters followed by combining marks are composed into single letters.
+// It's a partial fold of _compositions, not full NFC normalization.
+// If ascii is true - name is transliterated into ascii characters,
+// everything without transliteration is removed
+func SanitizeFilename(name string, ascii bool) string {
+	name = composeMarks(name)
+	if ascii {
+		name = transliterate(name)
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			// new lines, tabs, non-breaking spaces, etc.
+			return ' '
+		case r == utf8.RuneError || unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(_reservedChars, r):
+			return '_'
+		default:
+			return r
+		}
+	}, name)
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if _reservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+	return name
+}
+
+// composeMarks replace base letters followed by combining marks with
+// precomposed letters from _compositions. Unknown combinations are kept
+// as is, so result matches NFC only for letters listed there
+func composeMarks(s string) string {
+	if strings.IndexFunc(s, isCombining) < 0 {
+		return s
+	}
+	runes := []rune(s)
+	out := make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if n := len(out); n > 0 && isCombining(r) {
+			if c, ok := _composed[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = c
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func isCombining(r rune) bool {
+	return unicode.Is(unicode.Mn, r)
+}
+
+// transliterate convert s into ascii. Characters without known
+// transliteration are removed
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case len(_specials[r]) > 0:
+			b.WriteString(_specials[r])
+		case len(_cyrillic[unicode.ToLower(r)]) > 0:
+			latin := _cyrillic[unicode.ToLower(r)]
+			if unicode.IsUpper(r) {
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			b.WriteString(latin)
+		case _decomposed[r] != 0 && _decomposed[r] < utf8.RuneSelf:
+			// latin letter with diacritic
+			b.WriteRune(_decomposed[r])
+		}
+	}
+	return b.String()
+}
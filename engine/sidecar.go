@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+// SidecarOptions define which files are written next to downloaded song.
+// Sidecars share song's file name, like "song.info.json" for "song.mp3"
+type SidecarOptions struct {
+	// Info write extracted info and the downloaded format as json
+	Info bool
+	// ProcessedCover keep embedded cover as separate file. It's written
+	// after CoverOptions are applied, so it's cropped, shrunk or
+	// re-encoded the same way, not the original artwork
+	ProcessedCover bool
+	// CoverName is a file name of processed cover inside song's folder,
+	// like "cover.jpg". If empty - song's name with cover's extension is used
+	CoverName string
+	// Lyrics are formats of lyrics files: "srt", "vtt" or "lrc". Lyrics are
+	// taken from song's metadata or captions, see LyricsOptions
+	Lyrics []string
+}
+
+// sidecarInfo is a content of info sidecar
+type sidecarInfo struct {
+	*parsers.ExtractorInfo
+	// Format is the one, which was downloaded
+	Format parsers.Format
+}
+
+// writeSidecars write enabled sidecars of song saved at songPath. Cover is
+// moved from thumbnail, which is removed if it isn't needed
+func (e Engine) writeSidecars(songPath string, info *parsers.ExtractorInfo, format parsers.Format,
+	thumbnail string, lyrics loaders.Lyrics) {
+	opts := e.config.Sidecars
+	base := strings.TrimSuffix(songPath, path.Ext(songPath))
+	if opts.Info {
+		data, err := json.MarshalIndent(sidecarInfo{info, format}, "", "  ")
+		if err == nil {
+			err = writeSidecar(base+".info.json", data)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if len(lyrics.Lines) > 0 {
+		for _, ext := range opts.Lyrics {
+			text, err := lyrics.Format(ext)
+			if err == nil {
+				err = writeSidecar(base+"."+strings.ToLower(ext), []byte(text))
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	if len(thumbnail) < 1 {
+		return
+	}
+	if !opts.ProcessedCover {
+		_ = os.Remove(thumbnail)
+		return
+	}
+	coverPath := base + path.Ext(thumbnail)
+	if len(opts.CoverName) > 0 {
+		coverPath = path.Join(path.Dir(songPath), opts.CoverName)
+	}
+	if err := os.Rename(thumbnail, coverPath); err != nil {
+		log.Println(err)
+		_ = os.Remove(thumbnail)
+	}
+}
+
+// writeSidecar save data into file. File is replaced atomically
+func writeSidecar(filename string, data []byte) error {
+	temp, err := makeTempPath(path.Dir(filename), strings.TrimPrefix(path.Ext(filename), "."))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, filename); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+// InfoProcessor change extracted info before metadata is created from it
+type InfoProcessor func(info *parsers.ExtractorInfo)
+
+var (
+	// _bracketsRE match parts of title in round or square brackets
+	_bracketsRE = regexp.MustCompile(`\s*[(\[]([^()\[\]]*)[)\]]`)
+	// _noiseRE match content of brackets, which has nothing to do with song
+	_noiseRE = regexp.MustCompile(`(?i)^\s*(?:official\s*)?(?:music\s*|lyrics?\s*|audio\s*|hd\s*|hq\s*|4k\s*|` +
+		`video\s*|visuali[sz]er\s*|m/?v\s*|explicit\s*|clip\s*|premiere\s*)+$`)
+	// _featRE match featured artists mark, like "ft." or "feat"
+	_featRE = regexp.MustCompile(`(?i)^\s*(?:ft|feat|featuring)\.?\s+(.+)$`)
+	// _inlineFeatRE match featured artists outside of brackets, till the end
+	_inlineFeatRE = regexp.MustCompile(`(?i)\s+(?:ft|feat|featuring)\.?\s+(.+)$`)
+	// _artistsSepRE split list of featured artists. Plain "and" isn't
+	// a separator, because it's a part of names like "Simon and Garfunkel"
+	_artistsSepRE = regexp.MustCompile(`\s*[,&]\s*`)
+)
+
+// _titleSeparators split artist and title in titles like "Artist - Title"
+var _titleSeparators = []string{" - ", " – ", " — "}
+
+// ParseTitle return processor, which parse titles like
+// "Artist - Title (ft. Other) [Official Video]" into artist, title and
+// featured artists. Noise like "(Lyrics)" or "[Official Video]" is removed.
+// It's applied only to songs of given extractors, or to every song if
+// there are none
+func ParseTitle(extractors ...string) InfoProcessor {
+	return func(info *parsers.ExtractorInfo) {
+		if len(extractors) > 0 && !containsFold(extractors, info.Extractor) {
+			return
+		}
+		artist, title, featured := parseTitle(info.Title)
+		if len(title) < 1 {
+			return
+		}
+		info.Title = title
+		if len(artist) > 0 {
+			info.Artist = artist
+		}
+		info.Featured = append(info.Featured, featured...)
+	}
+}
+
+// parseTitle split raw title into artist (empty if title doesn't have
+// one), title and featured artists
+func parseTitle(raw string) (artist string, title string, featured []string) {
+	// brackets are processed first, so separators inside them are ignored
+	var kept []string
+	raw = _bracketsRE.ReplaceAllStringFunc(raw, func(part string) string {
+		content := _bracketsRE.FindStringSubmatch(part)[1]
+		if m := _featRE.FindStringSubmatch(content); m != nil {
+			featured = append(featured, splitArtists(m[1])...)
+			return ""
+		}
+		if _noiseRE.MatchString(content) {
+			return ""
+		}
+		kept = append(kept, strings.TrimSpace(part))
+		return "\x00"
+	})
+
+	if a, t, ok := splitArtistTitle(raw); ok {
+		artist, title = a, t
+		// meaningful brackets from artist's part are dropped
+		kept = kept[strings.Count(artist, "\x00"):]
+		// "Artist ft. Other - Title"
+		if m := _inlineFeatRE.FindStringSubmatchIndex(artist); m != nil {
+			featured = append(featured, splitArtists(artist[m[2]:m[3]])...)
+			artist = strings.TrimSpace(artist[:m[0]])
+		}
+	} else {
+		title = raw
+	}
+	// "Title ft. Other"
+	if m := _inlineFeatRE.FindStringSubmatchIndex(title); m != nil && !strings.Contains(title[m[0]:], "\x00") {
+		featured = append(featured, splitArtists(title[m[2]:m[3]])...)
+		title = title[:m[0]]
+	}
+
+	// return meaningful brackets back
+	for _, part := range kept {
+		title = strings.Replace(title, "\x00", " "+part, 1)
+	}
+	artist = strings.Replace(artist, "\x00", "", -1)
+	return cleanTitle(artist), cleanTitle(title), featured
+}
+
+// splitArtistTitle split title by the first separator. It's the only
+// artist and title splitter, used by ParseTitle, SplitArtistTitle and
+// chapters
+func splitArtistTitle(s string) (artist string, title string, ok bool) {
+	best := -1
+	var sep string
+	for _, sp := range _titleSeparators {
+		if i := strings.Index(s, sp); i >= 0 && (best < 0 || i < best) {
+			best, sep = i, sp
+		}
+	}
+	if best < 0 {
+		return "", "", false
+	}
+	artist = strings.TrimSpace(s[:best])
+	title = strings.TrimSpace(s[best+len(sep):])
+	if len(artist) < 1 || len(title) < 1 {
+		return "", "", false
+	}
+	return artist, title, true
+}
+
+// splitArtists split list like "A, B & C"
+func splitArtists(s string) []string {
+	var artists []string
+	for _, a := range _artistsSepRE.Split(s, -1) {
+		if a = strings.TrimSpace(a); len(a) > 0 {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
+
+// cleanTitle collapse spaces and remove quotes around title
+func cleanTitle(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	for _, q := range []string{`"`, "'", "«»", "“”"} {
+		open, close := q, q
+		if len([]rune(q)) == 2 {
+			open, close = string([]rune(q)[0]), string([]rune(q)[1])
+		}
+		if len(s) > len(open)+len(close) && strings.HasPrefix(s, open) && strings.HasSuffix(s, close) {
+			s = strings.TrimSpace(s[len(open) : len(s)-len(close)])
+		}
+	}
+	return strings.Trim(s, " -–—|")
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTitle(t *testing.T) {
+	tests := []struct {
+		raw      string
+		artist   string
+		title    string
+		featured string
+	}{
+		{".ethereal - ASTRAL (ft. OSIAS)", ".ethereal", "ASTRAL", "OSIAS"},
+		{"ÆSTRAL - reasons", "ÆSTRAL", "reasons", ""},
+		{"Artist - Song [Official Video]", "Artist", "Song", ""},
+		{"Artist – Song (Official Music Video) (Lyrics)", "Artist", "Song", ""},
+		{"Artist - Song (feat. A, B & C) [HD]", "Artist", "Song", "A,B,C"},
+		{"Artist ft. Guest - Song (Remix)", "Artist", "Song (Remix)", "Guest"},
+		{"Artist - Song ft. Guest", "Artist", "Song", "Guest"},
+		{`Artist - "Song" (Live)`, "Artist", `"Song" (Live)`, ""},
+		{`Artist - "Song"`, "Artist", "Song", ""},
+		{"(Remastered) Artist - Song", "Artist", "Song", ""},
+		{"Just a song (Lyric Video)", "", "Just a song", ""},
+		{"Simon and Garfunkel - The Boxer", "Simon and Garfunkel", "The Boxer", ""},
+		{"Artist - Song (ft. Simon and Garfunkel)", "Artist", "Song", "Simon and Garfunkel"},
+		{"Artist - Song ft. Andy, Sandra & Brand", "Artist", "Song", "Andy,Sandra,Brand"},
+		{"Artist – Song – Live", "Artist", "Song – Live", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			artist, title, featured := parseTitle(tt.raw)
+			if artist != tt.artist || title != tt.title || strings.Join(featured, ",") != tt.featured {
+				t.Errorf("parseTitle() = %q, %q, %q; want %q, %q, %q",
+					artist, title, featured, tt.artist, tt.title, tt.featured)
+			}
+		})
+	}
+}
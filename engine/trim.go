@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+// trimmed report whether only part of song is downloaded
+func (s *SongInfo) trimmed() bool {
+	return s.Start > 0 || s.End > 0
+}
+
+// trimRange return checked part of song. End 0 means the end of song
+func (s *SongInfo) trimRange() (start time.Duration, end time.Duration, err error) {
+	start, end = s.Start, s.End
+	duration := s.Info.Duration
+	if start < 0 || end < 0 || (end > 0 && end <= start) || (duration > 0 && start >= duration) {
+		return 0, 0, ErrInvalidRange{Start: start, End: end}
+	}
+	if duration > 0 && end >= duration {
+		end = 0
+	}
+	return start, end, nil
+}
+
+// duration return length of downloaded part of song. 0 means unknown
+func (s *SongInfo) duration() time.Duration {
+	start, end, err := s.trimRange()
+	if err != nil {
+		return 0
+	}
+	if end < 1 {
+		end = s.Info.Duration
+	}
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
+
+// trimmedInfo return copy of song's info, which describe only downloaded
+// part of song: its duration and chapters inside it
+func (s *SongInfo) trimmedInfo() (*parsers.ExtractorInfo, error) {
+	if !s.trimmed() {
+		return s.Info, nil
+	}
+	start, end, err := s.trimRange()
+	if err != nil {
+		return nil, err
+	}
+	info := *s.Info
+	info.Duration = s.duration()
+	info.Chapters = nil
+	for _, ch := range s.Info.Chapters {
+		chEnd := ch.End
+		if chEnd < 1 {
+			chEnd = s.Info.Duration
+		}
+		if (chEnd > 0 && chEnd <= start) || (end > 0 && ch.Start >= end) {
+			continue
+		}
+		ch.Start = clip(ch.Start-start, info.Duration)
+		if chEnd > 0 {
+			ch.End = clip(chEnd-start, info.Duration)
+		}
+		info.Chapters = append(info.Chapters, ch)
+	}
+	return &info, nil
+}
+
+// trimLyrics keep only lines shown inside downloaded part of song, shifted
+// to its beginning. Line, which started earlier but is still shown, begins
+// with the part, and lines are cut at its end
+func (s *SongInfo) trimLyrics(lyrics loaders.Lyrics) loaders.Lyrics {
+	start, end, err := s.trimRange()
+	if !s.trimmed() || err != nil {
+		return lyrics
+	}
+	lines := lyrics.Lines
+	lyrics.Lines = nil
+	for i, line := range lines {
+		lineEnd := line.Start + line.Duration
+		if line.Duration < 1 && i+1 < len(lines) {
+			// line lasts till the next one
+			lineEnd = lines[i+1].Start
+		}
+		if (line.Start < start && lineEnd <= start) || (end > 0 && line.Start >= end) {
+			continue
+		}
+		if line.Start < start {
+			if line.Duration > 0 {
+				line.Duration = lineEnd - start
+			}
+			line.Start = start
+		}
+		if end > 0 && line.Duration > 0 && lineEnd > end {
+			line.Duration = end - line.Start
+		}
+		line.Start -= start
+		lyrics.Lines = append(lyrics.Lines, line)
+	}
+	return lyrics
+}
+
+// sourceRange describe downloaded part of song, like "01:00:00-01:05:30"
+func (s *SongInfo) sourceRange() string {
+	start, end, _ := s.trimRange()
+	if end < 1 {
+		end = s.Info.Duration
+	}
+	if end < 1 {
+		return formatClock(start) + "-"
+	}
+	return formatClock(start) + "-" + formatClock(end)
+}
+
+// clip limit d to [0, max]. Max 0 means there is no upper limit
+func clip(d time.Duration, max time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+// formatClock format d like "01:02:03"
+func formatClock(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
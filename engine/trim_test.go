@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+func TestSongInfo_trimmedInfo(t *testing.T) {
+	info := &parsers.ExtractorInfo{
+		Duration: 10 * time.Minute,
+		Chapters: []parsers.Chapter{
+			{Title: "Intro", Start: 0, End: 2 * time.Minute},
+			{Title: "Song", Start: 2 * time.Minute, End: 7 * time.Minute},
+			{Title: "Outro", Start: 7 * time.Minute, End: 10 * time.Minute},
+		},
+	}
+	tests := []struct {
+		name     string
+		start    time.Duration
+		end      time.Duration
+		duration time.Duration
+		chapters []string
+		wantErr  bool
+	}{
+		{"whole", 0, 0, 10 * time.Minute, []string{"Intro", "Song", "Outro"}, false},
+		{"middle", 3 * time.Minute, 6 * time.Minute, 3 * time.Minute, []string{"Song"}, false},
+		{"till the end", 5 * time.Minute, 0, 5 * time.Minute, []string{"Song", "Outro"}, false},
+		{"end after song", time.Minute, time.Hour, 9 * time.Minute, []string{"Intro", "Song", "Outro"}, false},
+		{"start after song", 11 * time.Minute, 0, 0, nil, true},
+		{"end before start", 5 * time.Minute, time.Minute, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			song := &SongInfo{Info: info, Start: tt.start, End: tt.end}
+			got, err := song.trimmedInfo()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("trimmedInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var chapters []string
+			for _, ch := range got.Chapters {
+				if ch.Start < 0 || ch.End > got.Duration || ch.Start >= ch.End {
+					t.Errorf("chapter %q is outside of song: %v - %v", ch.Title, ch.Start, ch.End)
+				}
+				chapters = append(chapters, ch.Title)
+			}
+			if got.Duration != tt.duration || !reflect.DeepEqual(chapters, tt.chapters) {
+				t.Errorf("trimmedInfo() = %v %v, want %v %v", got.Duration, chapters, tt.duration, tt.chapters)
+			}
+		})
+	}
+	if len(info.Chapters) != 3 || info.Duration != 10*time.Minute {
+		t.Errorf("trimmedInfo() changed original info")
+	}
+}
+
+func TestSongInfo_trimLyrics(t *testing.T) {
+	lyrics := loaders.Lyrics{Language: "en", Lines: []loaders.LyricLine{
+		{Start: 10 * time.Second, Duration: 5 * time.Second, Text: "First"},
+		{Start: 20 * time.Second, Text: "Second"},
+		{Start: 30 * time.Second, Duration: 10 * time.Second, Text: "Third"},
+		{Start: 50 * time.Second, Text: "Last"},
+	}}
+	tests := []struct {
+		name  string
+		start time.Duration
+		end   time.Duration
+		want  []loaders.LyricLine
+	}{
+		{"whole", 0, 0, lyrics.Lines},
+		{"line is still shown", 35 * time.Second, 0, []loaders.LyricLine{
+			{Start: 0, Duration: 5 * time.Second, Text: "Third"},
+			{Start: 15 * time.Second, Text: "Last"},
+		}},
+		{"line lasts till the next one", 25 * time.Second, 0, []loaders.LyricLine{
+			{Start: 0, Text: "Second"},
+			{Start: 5 * time.Second, Duration: 10 * time.Second, Text: "Third"},
+			{Start: 25 * time.Second, Text: "Last"},
+		}},
+		{"line ends at start", 15 * time.Second, 0, []loaders.LyricLine{
+			{Start: 5 * time.Second, Text: "Second"},
+			{Start: 15 * time.Second, Duration: 10 * time.Second, Text: "Third"},
+			{Start: 35 * time.Second, Text: "Last"},
+		}},
+		{"line starts at start", 20 * time.Second, 0, []loaders.LyricLine{
+			{Start: 0, Text: "Second"},
+			{Start: 10 * time.Second, Duration: 10 * time.Second, Text: "Third"},
+			{Start: 30 * time.Second, Text: "Last"},
+		}},
+		{"line is cut at end", 12 * time.Second, 35 * time.Second, []loaders.LyricLine{
+			{Start: 0, Duration: 3 * time.Second, Text: "First"},
+			{Start: 8 * time.Second, Text: "Second"},
+			{Start: 18 * time.Second, Duration: 5 * time.Second, Text: "Third"},
+		}},
+		{"after the last line", 55 * time.Second, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			song := &SongInfo{Info: &parsers.ExtractorInfo{Duration: time.Minute}, Start: tt.start, End: tt.end}
+			got := song.trimLyrics(lyrics)
+			if got.Language != "en" || !reflect.DeepEqual(got.Lines, tt.want) {
+				t.Errorf("trimLyrics() = %+v, want %+v", got.Lines, tt.want)
+			}
+		})
+	}
+}
@@ -23,3 +23,15 @@ type ErrDownloadingError struct {
 type ErrUndefined struct {
 	engine.ErrUndefined
 }
+type ErrCancelled struct {
+	engine.ErrCancelled
+}
+type ErrArchived struct {
+	engine.ErrArchived
+}
+type ErrFileExists struct {
+	engine.ErrFileExists
+}
+type ErrInvalidRange struct {
+	engine.ErrInvalidRange
+}
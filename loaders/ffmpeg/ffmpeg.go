@@ -1,11 +1,22 @@
 package ffmpeg
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/camelva/erzo/engine"
-	"github.com/camelva/erzo/parsers"
-	"net/url"
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/loaders/id3"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type loader struct {
@@ -31,70 +42,216 @@ func (l loader) Name() string {
 func (l loader) Bin() string {
 	return l.bin
 }
-func (l loader) Get(u *url.URL, outName string) error {
+func (l loader) Get(ctx context.Context, job loaders.Job) error {
 	args := make([]string, 0)
-	args = append(args,
-		"-y",             // overwrite existing
-		"-i", u.String(), // input file
-		"-vn",
-		"-ar", "44100",
-		"-ac", "2",
-		"-b:a", "128k")
-	args = append(args, outName) // output name should always be latest element
+	args = append(args, "-y") // overwrite existing
+	input, output := seekArgs(job.Start, job.End, job.Profile.Codec == loaders.CodecCopy)
+	args = append(args, input...)
+	args = append(args, "-i", job.URL.String()) // input file
+	args = append(args, output...)
+	args = append(args, "-vn")
+	args = append(args, codecArgs(job.Profile)...)
+	if job.Progress != nil {
+		args = append(args, "-progress", "pipe:1", "-nostats")
+	}
+	args = append(args, job.Output) // output name should always be latest element
 	// if need debug
 	//args = append(args, "-report")
-	_, err := execute(l.Bin(), args...)
+	var err error
+	if job.Progress != nil {
+		err = executeProgress(ctx, l.Bin(), job, args...)
+	} else {
+		_, err = execute(ctx, l.Bin(), args...)
+	}
 	if err != nil {
+		if ctx.Err() != nil {
+			// don't leave partially written file after cancellation
+			_ = os.Remove(job.Output)
+			return ctx.Err()
+		}
 		return err
 	}
 	return nil
 }
-func (l loader) UpdateTags(filename string, metadata []string) error {
+
+// UpdateTags write tags into file. Tags of mp3 files are written by id3
+// package, because ffmpeg's muxer can't write lyrics frames (USLT, SYLT)
+// and drops them on every remux. Lyrics of m4a files are written as "lyrics"
+// key, which ffmpeg store as ©lyr atom
+func (l loader) UpdateTags(ctx context.Context, filename string, tags loaders.Tags) error {
+	if container(filename) == "mp3" {
+		return id3.WriteTags(filename, tags)
+	}
+	tempName, err := makeTemp(filename)
+	if err != nil {
+		return err
+	}
 	args := make([]string, 0)
 	args = append(args,
 		"-y",
-		"-i", filename,
-		"-c", "copy")
-	for _, el := range metadata {
-		args = append(args, "-metadata", el)
+		"-i", filename)
+	if len(tags.Chapters) > 0 {
+		chapters, err := writeChapters(filename, tags.Chapters)
+		if err != nil {
+			_ = os.Remove(tempName)
+			return err
+		}
+		defer os.Remove(chapters)
+		args = append(args,
+			"-i", chapters,
+			"-map", "0",
+			"-map_chapters", "1")
 	}
-	args = append(args, "temp.mp3") // output name should always be latest element
+	args = append(args, "-c", "copy")
+	args = append(args, metadataArgs(container(filename), tags)...)
+	args = append(args, tempName) // output name should always be latest element
 	// if need debug
 	//args = append(args, "-report")
-	_, err := execute(l.Bin(), args...)
-	//noinspection GoUnhandledErrorResult
-	defer os.Rename("temp.mp3", filename)
+	_, err = execute(ctx, l.Bin(), args...)
+	return finish(ctx, tempName, filename, err)
+}
+
+// AddThumbnail embed cover into file. Covers of mp3 files are written by
+// id3 package, so lyrics frames written by UpdateTags are kept
+func (l loader) AddThumbnail(ctx context.Context, filename string, thumb string) error {
+	if container(filename) == "mp3" {
+		return id3.WriteCover(filename, thumb)
+	}
+	args, err := thumbnailArgs(filename, thumb)
 	if err != nil {
 		return err
 	}
-	return nil
+	tempName, err := makeTemp(filename)
+	if err != nil {
+		return err
+	}
+	args = append(args, tempName)
+	//args = append(args, "-report")
+	_, err = execute(ctx, l.Bin(), args...)
+	return finish(ctx, tempName, filename, err)
 }
-func (l loader) AddThumbnail(filename string, thumb string) error {
-	tempName := "temp.mp3"
-	args := make([]string, 0)
+
+// Split cut part of file without re-encoding
+func (l loader) Split(ctx context.Context, filename string, output string, start time.Duration, end time.Duration) error {
+	inputArgs, outputArgs := seekArgs(start, end, true)
+	args := append([]string{"-y"}, inputArgs...)
+	args = append(args, "-i", filename)
+	args = append(args, outputArgs...)
 	args = append(args,
-		"-y",           // overwrite existing
-		"-i", filename, // input file
-		"-i", thumb,
-		//"-vn",
-		"-id3v2_version", "3",
+		"-map", "0",
+		"-map_metadata", "-1",
+		"-map_chapters", "-1",
 		"-c", "copy",
-		"-map", "0", "-map", "1",
-		"-metadata:s:v", "title=Album cover",
-		"-metadata:s:v", "comment=Cover (front)",
-		tempName)
-	//args = append(args, "-report")
-	_, err := execute(l.Bin(), args...)
+		output)
+	if _, err := execute(ctx, l.Bin(), args...); err != nil {
+		_ = os.Remove(output)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// seekArgs return ffmpeg's arguments for cutting part of input between
+// start and end (0 means the end of input): the ones going before "-i" and
+// after it. Input seeking is fast and accurate when stream is transcoded,
+// but with stream copy it starts from keyframe before start. So copied
+// streams are cut on output side, where every packet before start is
+// dropped
+func seekArgs(start time.Duration, end time.Duration, copy bool) (input []string, output []string) {
+	if copy {
+		if start > 0 {
+			output = append(output, "-ss", formatSeconds(start))
+		}
+		if end > start {
+			output = append(output, "-to", formatSeconds(end))
+		}
+		return input, output
+	}
+	if start > 0 {
+		input = append(input, "-ss", formatSeconds(start))
+	}
+	if end > start {
+		output = append(output, "-t", formatSeconds(end-start))
+	}
+	return input, output
+}
+
+// formatSeconds format d as seconds with milliseconds, like "75.250"
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// writeChapters save chapters into temporary file of ffmetadata format
+// next to filename. Caller should remove it
+func writeChapters(filename string, chapters []loaders.Chapter) (string, error) {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n")
+	for _, ch := range chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			ch.Start.Milliseconds(), ch.End.Milliseconds(), escape.Replace(ch.Title))
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".ffmpeg-*.txt")
 	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// makeTemp create unique temporary file next to filename, with the same
+// extension, so ffmpeg pick the same container for it
+func makeTemp(filename string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".ffmpeg-*"+path.Ext(filename))
+	if err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// finish replace filename with tempName if ffmpeg succeed, otherwise
+// tempName is removed and original file is kept untouched. Replaced file
+// keeps permissions of original one
+func finish(ctx context.Context, tempName string, filename string, err error) error {
+	if err != nil || ctx.Err() != nil {
+		_ = os.Remove(tempName)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	stat, err := os.Stat(filename)
+	if err == nil {
+		err = os.Chmod(tempName, stat.Mode().Perm())
+	}
+	if err != nil {
+		_ = os.Remove(tempName)
+		return err
+	}
+	if err := os.Rename(tempName, filename); err != nil {
+		_ = os.Remove(tempName)
 		return err
 	}
-	_ = os.Rename(tempName, filename)
 	return nil
 }
 
-func (l loader) Compatible(f parsers.Format) bool {
+func (l loader) Compatible(job loaders.Job) bool {
 	for _, p := range l.protocols {
-		if p != f.Protocol {
+		if p != job.Format.Protocol {
 			continue
 		}
 		return true
@@ -104,17 +261,194 @@ func (l loader) Compatible(f parsers.Format) bool {
 
 var config loader
 
+// codecArgs return ffmpeg's encoding arguments for given profile
+func codecArgs(p loaders.Profile) []string {
+	bitrate := func(def int) []string {
+		if p.Bitrate > 0 {
+			def = p.Bitrate
+		}
+		return []string{"-b:a", strconv.Itoa(def) + "k"}
+	}
+	quality := []string{"-q:a", strconv.Itoa(p.Quality)}
+
+	switch p.Codec {
+	case loaders.CodecCopy:
+		return []string{"-c:a", "copy"}
+	case loaders.CodecOpus:
+		args := []string{"-c:a", "libopus"}
+		if !p.VBR {
+			args = append(args, "-vbr", "off")
+		}
+		return append(args, bitrate(160)...)
+	case loaders.CodecAAC:
+		args := []string{"-c:a", "aac"}
+		if p.VBR {
+			return append(args, quality...)
+		}
+		return append(args, bitrate(192)...)
+	case loaders.CodecFLAC:
+		args := []string{"-c:a", "flac"}
+		if p.Quality > 0 {
+			args = append(args, "-compression_level", strconv.Itoa(p.Quality))
+		}
+		return args
+	case loaders.CodecVorbis:
+		args := []string{"-c:a", "libvorbis"}
+		if p.VBR {
+			return append(args, quality...)
+		}
+		return append(args, bitrate(160)...)
+	default:
+		args := []string{"-c:a", "libmp3lame", "-ar", "44100", "-ac", "2"}
+		if p.VBR {
+			return append(args, quality...)
+		}
+		return append(args, bitrate(128)...)
+	}
+}
+
+// _containerKeys rename metadata keys, which ffmpeg pass into container
+// as is, to the ones its readers know. Empty key means that ffmpeg can't
+// write field into container, so it's skipped
+var _containerKeys = map[string]map[string]string{
+	// ipod muxer write only fields with iTunes atoms
+	"m4a": {"publisher": "", "isrc": "", "url": ""},
+	// vorbis comments
+	"ogg":  {"publisher": "organization", "url": "website"},
+	"opus": {"publisher": "organization", "url": "website"},
+	"flac": {"publisher": "organization", "url": "website"},
+}
+
+// metadataArgs return ffmpeg's "-metadata" arguments for tags, with keys
+// of given container
+func metadataArgs(container string, tags loaders.Tags) []string {
+	keys := _containerKeys[container]
+	var args []string
+	for _, f := range tags.Fields() {
+		key, ok := keys[f.Key]
+		if !ok {
+			key = f.Key
+		}
+		if len(key) < 1 {
+			continue
+		}
+		args = append(args, "-metadata", key+"="+f.Value)
+	}
+	return args
+}
+
+// container return short name of file's container, based on its extension
+func container(filename string) string {
+	return strings.TrimPrefix(strings.ToLower(path.Ext(filename)), ".")
+}
+
+// thumbnailArgs return ffmpeg's arguments for embedding cover into file,
+// without output name. Each container store covers in its own way. Mp3
+// files aren't passed to ffmpeg, see AddThumbnail
+func thumbnailArgs(filename string, thumb string) ([]string, error) {
+	switch container(filename) {
+	case "m4a", "flac":
+		return []string{
+			"-y",
+			"-i", filename,
+			"-i", thumb,
+			"-c", "copy",
+			"-map", "0:a", "-map", "1",
+			"-disposition:v:0", "attached_pic",
+			"-metadata:s:v", "comment=Cover (front)",
+		}, nil
+	case "ogg", "opus":
+		// ogg can't hold cover as separate stream, so it's stored
+		// inside vorbis comment
+		block, err := pictureBlock(thumb)
+		if err != nil {
+			return nil, err
+		}
+		return []string{
+			"-y",
+			"-i", filename,
+			"-c", "copy",
+			"-map", "0:a",
+			"-metadata:s:a:0", "METADATA_BLOCK_PICTURE=" + block,
+		}, nil
+	default:
+		return []string{
+			"-y",
+			"-i", filename,
+			"-i", thumb,
+			"-c", "copy",
+			"-map", "0", "-map", "1",
+			"-disposition:v:0", "attached_pic",
+		}, nil
+	}
+}
+
 func findBin() string {
 	bin := "ffmpeg"
 	path, err := exec.LookPath(bin)
 	if err != nil {
-		path = bin
+		return ""
 	}
 	return path
 }
 
-func execute(app string, args ...string) ([]byte, error) {
-	cmd := exec.Command(app, args...)
+// executeProgress run app with given args, parse its "-progress pipe:1"
+// output and report it to job. Process will be killed when ctx is done
+func executeProgress(ctx context.Context, app string, job loaders.Job, args ...string) error {
+	cmd := exec.CommandContext(ctx, app, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	readProgress(stdout, job)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// readProgress parse output of "-progress" option and report it to job.
+// Progress is printed as blocks of key=value lines, every block ends with
+// "progress=continue" or "progress=end"
+func readProgress(r io.Reader, job loaders.Job) {
+	var p loaders.Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "out_time_us", "out_time_ms":
+			// despite its name, out_time_ms is in microseconds too
+			if us, err := strconv.ParseInt(kv[1], 10, 64); err == nil && us >= 0 {
+				p.Time = time.Duration(us) * time.Microsecond
+			}
+		case "total_size":
+			if size, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+				p.Bytes = size
+			}
+		case "progress":
+			job.Report(p)
+		}
+	}
+}
+
+// lastLine return last non-empty line of s
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// execute run app with given args. Process will be killed when ctx is done
+func execute(ctx context.Context, app string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, app, args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return out, err
@@ -0,0 +1,434 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/loaders/id3"
+)
+
+// _fakeFFmpeg remember its arguments next to itself and copy the first
+// input into output, which is always the last argument
+const _fakeFFmpeg = `#!/bin/sh
+printf '%s\n' "$@" > "$0.args"
+in=
+prev=
+for arg in "$@"; do
+	if [ "$prev" = "-i" ] && [ -z "$in" ]; then
+		in=$arg
+	fi
+	prev=$arg
+done
+cp "$in" "$prev"
+`
+
+// fakeLoader return loader running fake ffmpeg from dir and function,
+// which return arguments of its last run
+func fakeLoader(t *testing.T, dir string) (loader, func() string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	bin := filepath.Join(dir, "ffmpeg")
+	if err := ioutil.WriteFile(bin, []byte(_fakeFFmpeg), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return loader{name: "ffmpeg", bin: bin}, func() string {
+		args, _ := ioutil.ReadFile(bin + ".args")
+		_ = os.Remove(bin + ".args")
+		return string(args)
+	}
+}
+
+func TestMakeTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	song := filepath.Join(dir, "song.mp3")
+	if err := ioutil.WriteFile(song, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent taggers of the same file never share temporary file
+	const workers = 32
+	names := make([]string, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			names[i], errs[i] = makeTemp(song)
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[string]bool, workers)
+	for i, name := range names {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		base := filepath.Base(name)
+		if seen[name] || filepath.Dir(name) != dir || !strings.HasPrefix(base, ".ffmpeg-") || filepath.Ext(base) != ".mp3" {
+			t.Errorf("makeTemp() = %s", name)
+		}
+		seen[name] = true
+	}
+
+	// failed run keep original file and remove temporary one
+	if err := ioutil.WriteFile(names[0], []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := finish(context.Background(), names[0], song, errors.New("ffmpeg failed")); err == nil {
+		t.Errorf("finish() lost error")
+	}
+	if _, err := os.Stat(names[0]); !os.IsNotExist(err) {
+		t.Errorf("finish() left temporary file")
+	}
+	if data, _ := ioutil.ReadFile(song); string(data) != "original" {
+		t.Errorf("finish() changed original file after error: %q", data)
+	}
+
+	// successful run replace original file, keeping its permissions
+	if err := os.Chmod(song, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(names[1], []byte("tagged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := finish(context.Background(), names[1], song, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(song); string(data) != "tagged" {
+		t.Errorf("finish() didn't replace original file: %q", data)
+	}
+	stat, err := os.Stat(song)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != 0640 {
+		t.Errorf("mode after finish() = %v, want %v", stat.Mode().Perm(), os.FileMode(0640))
+	}
+	for _, name := range names[2:] {
+		_ = os.Remove(name)
+	}
+}
+
+func TestSeekArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      time.Duration
+		end        time.Duration
+		copy       bool
+		wantInput  []string
+		wantOutput []string
+	}{
+		{"whole", 0, 0, false, nil, nil},
+		{"transcode", 90 * time.Second, 150 * time.Second, false, []string{"-ss", "90.000"}, []string{"-t", "60.000"}},
+		{"transcode till the end", 1500 * time.Millisecond, 0, false, []string{"-ss", "1.500"}, nil},
+		{"copy", 90 * time.Second, 150 * time.Second, true, nil, []string{"-ss", "90.000", "-to", "150.000"}},
+		{"copy from beginning", 0, time.Minute, true, nil, []string{"-to", "60.000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, output := seekArgs(tt.start, tt.end, tt.copy)
+			if !reflect.DeepEqual(input, tt.wantInput) || !reflect.DeepEqual(output, tt.wantOutput) {
+				t.Errorf("seekArgs() = %q %q, want %q %q", input, output, tt.wantInput, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestReadProgress(t *testing.T) {
+	block := func(us string, size string, state string) string {
+		return "bitrate=128.0kbits/s\ntotal_size=" + size + "\nout_time_us=" + us +
+			"\nout_time_ms=" + us + "\nout_time=00:00:01.500000\nspeed=10x\nprogress=" + state + "\n"
+	}
+	tests := []struct {
+		name   string
+		output string
+		want   []loaders.Progress
+	}{
+		{
+			"blocks",
+			block("1500000", "24000", "continue") + block("3000000", "48000", "end"),
+			[]loaders.Progress{
+				{Bytes: 24000, Time: 1500 * time.Millisecond, Duration: time.Minute},
+				{Bytes: 48000, Time: 3 * time.Second, Duration: time.Minute},
+			},
+		},
+		{
+			// old ffmpeg prints only out_time_ms, which is in microseconds
+			"out_time_ms only",
+			"total_size=100\nout_time_ms=250000\nprogress=end\n",
+			[]loaders.Progress{{Bytes: 100, Time: 250 * time.Millisecond, Duration: time.Minute}},
+		},
+		{
+			// position is unknown before the first packet is written
+			"unknown values",
+			"total_size=N/A\nout_time_us=-9223372036854775807\nprogress=continue\n" +
+				"total_size=10\nout_time_us=N/A\nprogress=continue\n",
+			[]loaders.Progress{{Duration: time.Minute}, {Bytes: 10, Duration: time.Minute}},
+		},
+		{"incomplete block", "total_size=10\nout_time_us=100\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []loaders.Progress
+			job := loaders.Job{Duration: time.Minute, Progress: func(p loaders.Progress) { got = append(got, p) }}
+			readProgress(strings.NewReader(tt.output), job)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readProgress() reported %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodecArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile loaders.Profile
+		want    []string
+	}{
+		{"copy", loaders.Profile{Codec: loaders.CodecCopy, Bitrate: 320}, []string{"-c:a", "copy"}},
+		{"mp3 default", loaders.DefaultProfile, []string{"-c:a", "libmp3lame", "-ar", "44100", "-ac", "2", "-b:a", "128k"}},
+		{"mp3 bitrate", loaders.Profile{Codec: loaders.CodecMP3, Bitrate: 320}, []string{"-c:a", "libmp3lame", "-ar", "44100", "-ac", "2", "-b:a", "320k"}},
+		{"mp3 vbr", loaders.Profile{Codec: loaders.CodecMP3, VBR: true, Quality: 2}, []string{"-c:a", "libmp3lame", "-ar", "44100", "-ac", "2", "-q:a", "2"}},
+		{"aac", loaders.Profile{Codec: loaders.CodecAAC}, []string{"-c:a", "aac", "-b:a", "192k"}},
+		{"aac vbr", loaders.Profile{Codec: loaders.CodecAAC, VBR: true, Quality: 1}, []string{"-c:a", "aac", "-q:a", "1"}},
+		{"opus", loaders.Profile{Codec: loaders.CodecOpus, Bitrate: 96}, []string{"-c:a", "libopus", "-vbr", "off", "-b:a", "96k"}},
+		{"opus vbr", loaders.Profile{Codec: loaders.CodecOpus, VBR: true}, []string{"-c:a", "libopus", "-b:a", "160k"}},
+		{"vorbis", loaders.Profile{Codec: loaders.CodecVorbis, VBR: true, Quality: 6}, []string{"-c:a", "libvorbis", "-q:a", "6"}},
+		{"flac", loaders.Profile{Codec: loaders.CodecFLAC, Quality: 8}, []string{"-c:a", "flac", "-compression_level", "8"}},
+		{"flac default", loaders.Profile{Codec: loaders.CodecFLAC}, []string{"-c:a", "flac"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codecArgs(tt.profile); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codecArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThumbnailArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	thumb := filepath.Join(dir, "cover.png")
+	if err := ioutil.WriteFile(thumb, cover.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filename string
+		want     []string
+		// picture is true, when cover is stored as METADATA_BLOCK_PICTURE
+		picture bool
+	}{
+		{"song.m4a", []string{"-y", "-i", "song.m4a", "-i", thumb, "-c", "copy",
+			"-map", "0:a", "-map", "1", "-disposition:v:0", "attached_pic", "-metadata:s:v", "comment=Cover (front)"}, false},
+		{"song.ogg", []string{"-y", "-i", "song.ogg", "-c", "copy", "-map", "0:a"}, true},
+		{"song.OPUS", []string{"-y", "-i", "song.OPUS", "-c", "copy", "-map", "0:a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, err := thumbnailArgs(tt.filename, thumb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.picture {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("thumbnailArgs() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+			if len(got) != len(tt.want)+2 || !reflect.DeepEqual(got[:len(tt.want)], tt.want) || got[len(tt.want)] != "-metadata:s:a:0" {
+				t.Fatalf("thumbnailArgs() = %q", got)
+			}
+			const key = "METADATA_BLOCK_PICTURE="
+			comment := got[len(got)-1]
+			if !strings.HasPrefix(comment, key) {
+				t.Fatalf("comment = %q, want %s...", comment, key)
+			}
+			block, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(comment, key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// FLAC picture block: type, mime, description, width, height,
+			// color depth, number of colors and picture itself
+			var want bytes.Buffer
+			for _, f := range []interface{}{
+				uint32(3), uint32(len("image/png")), []byte("image/png"),
+				uint32(len("Cover (front)")), []byte("Cover (front)"),
+				uint32(4), uint32(3), uint32(24), uint32(0),
+				uint32(cover.Len()), cover.Bytes(),
+			} {
+				_ = binary.Write(&want, binary.BigEndian, f)
+			}
+			if !bytes.Equal(block, want.Bytes()) {
+				t.Errorf("picture block = %q, want %q", block, want.Bytes())
+			}
+		})
+	}
+
+	if _, err := thumbnailArgs("song.ogg", filepath.Join(dir, "missing.png")); err == nil {
+		t.Errorf("thumbnailArgs() with missing cover: expected error")
+	}
+}
+
+func TestLoader_UpdateTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, lastArgs := fakeLoader(t, dir)
+	cover := filepath.Join(dir, "cover.jpg")
+	if err := ioutil.WriteFile(cover, []byte("\xff\xd8\xff\xe0fake jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tags := loaders.Tags{
+		Title:     "Song",
+		Genre:     "Electronic",
+		Comment:   "Fixture song",
+		Publisher: "Fixture Records",
+		ISRC:      "QZFIX2000001",
+		URL:       "https://fixtures.test/song/1",
+		Lyrics: loaders.Lyrics{Language: "en", Lines: []loaders.LyricLine{
+			{Start: 1500 * time.Millisecond, Text: "First line"},
+			{Start: 75 * time.Second, Text: "Second line"},
+		}},
+		Chapters: []loaders.Chapter{
+			{Title: "Intro", End: 30 * time.Second},
+			{Title: "Song", Start: 30 * time.Second, End: 2 * time.Minute},
+		},
+	}
+
+	t.Run("mp3", func(t *testing.T) {
+		song := filepath.Join(dir, "song.mp3")
+		if err := ioutil.WriteFile(song, bytes.Repeat([]byte("\xff\xfbaudio;"), 64), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.UpdateTags(context.Background(), song, tags); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.AddThumbnail(context.Background(), song, cover); err != nil {
+			t.Fatal(err)
+		}
+		tag, err := id3.ReadFile(song)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := []struct {
+			id   string
+			want string
+		}{
+			{"TIT2", "Song"},
+			{"TCON", "Electronic"},
+			{"TPUB", "Fixture Records"},
+			{"TSRC", "QZFIX2000001"},
+		}
+		for _, f := range frames {
+			if got := tag.Text(f.id); got != f.want {
+				t.Errorf("%s = %q, want %q", f.id, got, f.want)
+			}
+		}
+		if woas, _ := tag.Get("WOAS"); string(woas) != tags.URL {
+			t.Errorf("WOAS = %q, want %q", woas, tags.URL)
+		}
+		if comm, _ := tag.Get("COMM"); !bytes.HasSuffix(comm, []byte("eng\x00Fixture song")) {
+			t.Errorf("COMM = %q", comm)
+		}
+		if _, ok := tag.Get("TXXX"); ok {
+			t.Errorf("standard fields are written as TXXX")
+		}
+		if args := lastArgs(); len(args) > 0 {
+			t.Errorf("mp3 is passed to ffmpeg: %q", args)
+		}
+		if uslt, _ := tag.Get("USLT"); !bytes.HasSuffix(uslt, []byte("eng\x00First line\nSecond line")) {
+			t.Errorf("USLT = %q", uslt)
+		}
+		if sylt, _ := tag.Get("SYLT"); !bytes.Contains(sylt, []byte("Second line\x00\x00\x01\x24\xf8")) {
+			t.Errorf("SYLT = %q", sylt)
+		}
+		for _, id := range []string{"CHAP", "CTOC", "APIC"} {
+			if _, ok := tag.Get(id); !ok {
+				t.Errorf("%s isn't written", id)
+			}
+		}
+	})
+
+	t.Run("m4a", func(t *testing.T) {
+		song := filepath.Join(dir, "song.m4a")
+		if err := ioutil.WriteFile(song, []byte("fake m4a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.UpdateTags(context.Background(), song, tags); err != nil {
+			t.Fatal(err)
+		}
+		// ffmpeg store "lyrics" key of mp4 files as ©lyr atom
+		args := lastArgs()
+		if !strings.Contains(args, "-metadata\nlyrics=First line\nSecond line\n") || !strings.Contains(args, "-map_chapters\n1\n") {
+			t.Errorf("ffmpeg arguments = %q", args)
+		}
+		if data, _ := ioutil.ReadFile(song); string(data) != "fake m4a" {
+			t.Errorf("song = %q", data)
+		}
+	})
+}
+
+func TestMetadataArgs(t *testing.T) {
+	tags := loaders.Tags{
+		Title:     "Song",
+		Comment:   "Fixture song",
+		Publisher: "Label",
+		ISRC:      "QZFIX2000001",
+		URL:       "https://fixtures.test/song/1",
+		Custom:    map[string]string{"mood": "calm"},
+	}
+	tests := []struct {
+		container string
+		want      []string
+	}{
+		{"m4a", []string{"title=Song", "comment=Fixture song", "mood=calm"}},
+		{"opus", []string{"title=Song", "comment=Fixture song", "organization=Label",
+			"isrc=QZFIX2000001", "website=https://fixtures.test/song/1", "mood=calm"}},
+		{"flac", []string{"title=Song", "comment=Fixture song", "organization=Label",
+			"isrc=QZFIX2000001", "website=https://fixtures.test/song/1", "mood=calm"}},
+		{"mka", []string{"title=Song", "comment=Fixture song", "publisher=Label",
+			"isrc=QZFIX2000001", "url=https://fixtures.test/song/1", "mood=calm"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.container, func(t *testing.T) {
+			var want []string
+			for _, value := range tt.want {
+				want = append(want, "-metadata", value)
+			}
+			if got := metadataArgs(tt.container, tags); !reflect.DeepEqual(got, want) {
+				t.Errorf("metadataArgs() = %q, want %q", got, want)
+			}
+		})
+	}
+}
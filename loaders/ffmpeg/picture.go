@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxPictureArg is a maximum size of encoded picture, which still can be
+// safely passed as command line argument
+const maxPictureArg = 100 * 1024
+
+// pictureBlock return base64 encoded FLAC picture block, used by ogg
+// containers as METADATA_BLOCK_PICTURE vorbis comment
+func pictureBlock(thumb string) (string, error) {
+	data, err := ioutil.ReadFile(thumb)
+	if err != nil {
+		return "", err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("can't decode cover: %w", err)
+	}
+	mime := http.DetectContentType(data)
+	desc := "Cover (front)"
+
+	var buf bytes.Buffer
+	fields := []interface{}{
+		uint32(3), // picture type - front cover
+		uint32(len(mime)), []byte(mime),
+		uint32(len(desc)), []byte(desc),
+		uint32(cfg.Width), uint32(cfg.Height),
+		uint32(24), // color depth
+		uint32(0),  // number of colors, 0 for non-indexed images
+		uint32(len(data)), data,
+	}
+	for _, f := range fields {
+		if err := binary.Write(&buf, binary.BigEndian, f); err != nil {
+			return "", err
+		}
+	}
+	block := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(block) > maxPictureArg {
+		return "", fmt.Errorf("cover is too large for ogg container")
+	}
+	return block, nil
+}
@@ -0,0 +1,337 @@
+// Package hls implement loader, which download HLS streams without any
+// external tools. Segments are fetched concurrently, decrypted if needed and
+// concatenated into output file, so it's used only when stream can be
+// copied as is
+package hls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/camelva/erzo/engine"
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/loaders/id3"
+	"github.com/camelva/erzo/utils"
+)
+
+const (
+	// defaultWorkers is a number of segments downloaded at once
+	defaultWorkers = 4
+	// defaultRetries is a number of attempts to fetch single segment
+	defaultRetries = 3
+)
+
+var ErrBadPadding = errors.New("invalid padding of decrypted segment")
+
+type loader struct {
+	name    string
+	client  *http.Client
+	workers int
+	retries int
+}
+
+func init() {
+	engine.AddLoader(New())
+}
+
+// New return hls loader instance
+func New() loaders.Loader {
+	return loader{
+		name:    "hls",
+		client:  http.DefaultClient,
+		workers: defaultWorkers,
+		retries: defaultRetries,
+	}
+}
+
+func (l loader) Name() string {
+	return l.name
+}
+
+// Bin return empty string, because loader doesn't need any binary
+func (l loader) Bin() string {
+	return ""
+}
+
+// Compatible report whether stream's segments can be just concatenated into
+// output file. It's true for mp3 and ogg segments and fragmented mp4
+func (l loader) Compatible(job loaders.Job) bool {
+	if job.Profile.Codec != loaders.CodecCopy || job.Format.Protocol != "hls" {
+		return false
+	}
+	switch job.Format.Codec {
+	case loaders.CodecMP3:
+		return job.Format.Type == "mpeg"
+	case loaders.CodecOpus, loaders.CodecVorbis:
+		return job.Format.Type == "ogg"
+	case loaders.CodecAAC:
+		return job.Format.Type == "mp4"
+	default:
+		return false
+	}
+}
+
+// Get download every segment of stream and concatenate them into job.Output.
+// Trimmed jobs get only segments, which overlap requested part. Segments
+// aren't cut, so their bounds are reported to job.Cut
+func (l loader) Get(ctx context.Context, job loaders.Job) error {
+	pl, err := l.playlist(ctx, job.URL)
+	if err != nil {
+		return err
+	}
+
+	partName := job.Output + ".part"
+	f, err := os.Create(partName)
+	if err != nil {
+		return err
+	}
+	// remove partial file on any error, including cancellation
+	fail := func(err error) error {
+		_ = f.Close()
+		_ = os.Remove(partName)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	keys := newKeyCache(l)
+	segments, from, to := pl.slice(job.Start, job.End)
+	if job.Trimmed() {
+		job.ReportCut(from, to)
+	}
+	progress := loaders.Progress{Duration: duration(segments)}
+	if pl.init != nil {
+		segments = append([]segment{*pl.init}, segments...)
+	}
+	for start := 0; start < len(segments); start += l.workers {
+		end := start + l.workers
+		if end > len(segments) {
+			end = len(segments)
+		}
+		batch, err := l.fetchBatch(ctx, segments[start:end], keys)
+		if err != nil {
+			return fail(err)
+		}
+		for i, data := range batch {
+			if _, err := f.Write(data); err != nil {
+				return fail(err)
+			}
+			progress.Bytes += int64(len(data))
+			progress.Time += segments[start+i].duration
+		}
+		job.Report(progress)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(partName)
+		return err
+	}
+	return os.Rename(partName, job.Output)
+}
+
+// UpdateTags write tags into file. Only mp3 files are supported
+func (l loader) UpdateTags(ctx context.Context, filename string, tags loaders.Tags) error {
+	return id3.WriteTags(filename, tags)
+}
+
+// AddThumbnail embed cover into file. Only mp3 files are supported
+func (l loader) AddThumbnail(ctx context.Context, filename string, thumb string) error {
+	return id3.WriteCover(filename, thumb)
+}
+
+// playlist fetch media playlist. If u point to master playlist - its variant
+// with the highest bandwidth is used
+func (l loader) playlist(ctx context.Context, u *url.URL) (*playlist, error) {
+	data, err := l.fetch(ctx, u, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	pl, err := parsePlaylist(data, u)
+	if err != nil {
+		return nil, err
+	}
+	if !pl.isMaster() {
+		return pl, nil
+	}
+	best := pl.bestVariant()
+	data, err = l.fetch(ctx, best.uri, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	pl, err = parsePlaylist(data, best.uri)
+	if err != nil {
+		return nil, err
+	}
+	if pl.isMaster() {
+		return nil, fmt.Errorf("%w: nested master playlists", ErrInvalidPlaylist)
+	}
+	return pl, nil
+}
+
+// fetchBatch download segments concurrently. Result keep segments order.
+// When one segment fails, the rest are cancelled, so its error is returned
+// instead of their cancellations
+func (l loader) fetchBatch(parent context.Context, segments []segment, keys *keyCache) ([][]byte, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	result := make([][]byte, len(segments))
+	errs := make([]error, len(segments))
+	var wg sync.WaitGroup
+	for i := range segments {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := l.fetchSegment(ctx, segments[i], keys)
+			if err != nil {
+				errs[i] = err
+				cancel()
+				return
+			}
+			result[i] = data
+		}(i)
+	}
+	wg.Wait()
+	var cancelled error
+	for _, err := range errs {
+		if errors.Is(err, context.Canceled) {
+			cancelled = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cancelled != nil {
+		if parent.Err() != nil {
+			return nil, parent.Err()
+		}
+		return nil, cancelled
+	}
+	return result, nil
+}
+
+func (l loader) fetchSegment(ctx context.Context, seg segment, keys *keyCache) ([]byte, error) {
+	data, err := l.fetch(ctx, seg.uri, seg.offset, seg.length)
+	if err != nil {
+		return nil, err
+	}
+	if seg.key == nil {
+		return data, nil
+	}
+	k, err := keys.get(ctx, seg.key.uri)
+	if err != nil {
+		return nil, err
+	}
+	iv := seg.key.iv
+	if iv == nil {
+		// when iv is missing, media sequence number is used instead
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(seg.sequence))
+	}
+	return decrypt(data, k, iv)
+}
+
+// fetch download u with retries. If length > 0 - only this sub-range is requested
+func (l loader) fetch(ctx context.Context, u *url.URL, offset int64, length int64) ([]byte, error) {
+	var lastErr error
+	for i := 0; i < l.retries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(i) * 500 * time.Millisecond):
+			}
+		}
+		data, err := l.fetchOnce(ctx, u, offset, length)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (l loader) fetchOnce(ctx context.Context, u *url.URL, offset int64, length int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", utils.UserAgent)
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, u.Path)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// decrypt AES-128-CBC encrypted segment and remove its PKCS#7 padding
+func decrypt(data []byte, k []byte, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 || len(data) < aes.BlockSize {
+		return nil, fmt.Errorf("encrypted segment size isn't multiple of block size")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad < 1 || pad > aes.BlockSize {
+		return nil, ErrBadPadding
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, ErrBadPadding
+		}
+	}
+	return out[:len(out)-pad], nil
+}
+
+// keyCache fetch every encryption key only once
+type keyCache struct {
+	l    loader
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func newKeyCache(l loader) *keyCache {
+	return &keyCache{l: l, keys: make(map[string][]byte)}
+}
+
+func (c *keyCache) get(ctx context.Context, u *url.URL) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if k, ok := c.keys[u.String()]; ok {
+		return k, nil
+	}
+	k, err := c.l.fetch(ctx, u, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(k) != 16 {
+		return nil, fmt.Errorf("invalid key size: %d", len(k))
+	}
+	c.keys[u.String()] = k
+	return k, nil
+}
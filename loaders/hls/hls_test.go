@@ -0,0 +1,317 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// encrypt segment the same way as HLS packagers do
+func encrypt(t *testing.T, data []byte, iv []byte) []byte {
+	block, err := aes.NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+	return out
+}
+
+func sequenceIV(n int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(n))
+	return iv
+}
+
+// newServer serve files from map. Paths listed in flaky fail on first request
+func newServer(files map[string][]byte, flaky ...string) *httptest.Server {
+	var mu sync.Mutex
+	failed := make(map[string]bool)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		for _, p := range flaky {
+			if p == r.URL.Path && !failed[p] {
+				failed[p] = true
+				mu.Unlock()
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		mu.Unlock()
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+}
+
+func TestLoader_Get(t *testing.T) {
+	segments := make([][]byte, 7)
+	for i := range segments {
+		segments[i] = []byte(strings.Repeat(fmt.Sprintf("segment-%d;", i), 10+i))
+	}
+	want := bytes.Join(segments, nil)
+
+	plain := map[string][]byte{}
+	var plainList strings.Builder
+	plainList.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:10\n")
+	for i, s := range segments {
+		name := fmt.Sprintf("/plain/%d.mp3", i)
+		plain[name] = s
+		fmt.Fprintf(&plainList, "#EXTINF:9.5,\n%d.mp3\n", i)
+	}
+	plainList.WriteString("#EXT-X-ENDLIST\n")
+	plain["/plain/index.m3u8"] = []byte(plainList.String())
+
+	explicitIV := bytes.Repeat([]byte{7}, aes.BlockSize)
+	encrypted := map[string][]byte{"/enc/key.bin": testKey}
+	var encList strings.Builder
+	encList.WriteString("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:5\n")
+	encList.WriteString(`#EXT-X-KEY:METHOD=AES-128,URI="key.bin"` + "\n")
+	for i, s := range segments {
+		if i == 4 {
+			encList.WriteString(`#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0x07070707070707070707070707070707` + "\n")
+		}
+		iv := sequenceIV(5 + i)
+		if i >= 4 {
+			iv = explicitIV
+		}
+		name := fmt.Sprintf("/enc/%d.ts", i)
+		encrypted[name] = encrypt(t, s, iv)
+		fmt.Fprintf(&encList, "#EXTINF:10,\n%d.ts\n", i)
+	}
+	encrypted["/enc/index.m3u8"] = []byte(encList.String())
+
+	master := map[string][]byte{
+		"/master/index.m3u8": []byte("#EXTM3U\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=64000,CODECS=\"mp4a.40.34\"\nlow/index.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS=\"mp4a.40.34\"\nhigh/index.m3u8\n"),
+		"/master/low/index.m3u8":  []byte("#EXTM3U\n#EXTINF:1,\nlow.mp3\n#EXT-X-ENDLIST\n"),
+		"/master/low/low.mp3":     []byte("wrong variant"),
+		"/master/high/index.m3u8": plain["/plain/index.m3u8"],
+	}
+	for i, s := range segments {
+		master[fmt.Sprintf("/master/high/%d.mp3", i)] = s
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		flaky   []string
+		path    string
+		want    []byte
+		wantErr bool
+	}{
+		{"plain segments", plain, nil, "/plain/index.m3u8", want, false},
+		{"aes-128 encrypted", encrypted, nil, "/enc/index.m3u8", want, false},
+		{"master playlist", master, nil, "/master/index.m3u8", want, false},
+		{"retry failed segment", plain, []string{"/plain/3.mp3", "/plain/index.m3u8"}, "/plain/index.m3u8", want, false},
+		{"missing playlist", plain, nil, "/nothing.m3u8", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(tt.files, tt.flaky...)
+			defer srv.Close()
+
+			dir, err := ioutil.TempDir("", "hls")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			u, _ := url.Parse(srv.URL + tt.path)
+			out := filepath.Join(dir, "out.mp3")
+			l := loader{name: "hls", client: srv.Client(), workers: 3, retries: 2}
+			var last loaders.Progress
+			job := loaders.Job{URL: u, Output: out, Progress: func(p loaders.Progress) { last = p }}
+			err = l.Get(context.Background(), job)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := os.Stat(out + ".part"); !os.IsNotExist(err) {
+					t.Errorf("Get() left partial file")
+				}
+				return
+			}
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Get() wrote %d bytes, want %d", len(got), len(tt.want))
+			}
+			if last.Bytes != int64(len(tt.want)) || last.Ratio() != 1 {
+				t.Errorf("Get() reported %d bytes (%.2f), want %d", last.Bytes, last.Ratio(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestLoader_GetTrimmed(t *testing.T) {
+	files := map[string][]byte{}
+	var list strings.Builder
+	list.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:10\n")
+	for i := 0; i < 6; i++ {
+		files[fmt.Sprintf("/%d.mp3", i)] = []byte(fmt.Sprintf("segment-%d;", i))
+		fmt.Fprintf(&list, "#EXTINF:10,\n%d.mp3\n", i)
+	}
+	list.WriteString("#EXT-X-ENDLIST\n")
+	files["/index.m3u8"] = []byte(list.String())
+	srv := newServer(files)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "hls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		start time.Duration
+		end   time.Duration
+		want  string
+		// wantCut is reported part, nil if it's exactly requested one
+		wantCut *[2]time.Duration
+	}{
+		{"middle", 15 * time.Second, 30 * time.Second, "segment-1;segment-2;", &[2]time.Duration{10 * time.Second, 30 * time.Second}},
+		{"till the end", 45 * time.Second, 0, "segment-4;segment-5;", &[2]time.Duration{40 * time.Second, 0}},
+		{"from the beginning", 0, 10 * time.Second, "segment-0;", nil},
+		{"last segment", 50 * time.Second, time.Minute, "segment-5;", &[2]time.Duration{50 * time.Second, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(srv.URL + "/index.m3u8")
+			out := filepath.Join(dir, "out.mp3")
+			l := loader{name: "hls", client: srv.Client(), workers: 3, retries: 2}
+			var last loaders.Progress
+			var cut *[2]time.Duration
+			job := loaders.Job{URL: u, Output: out, Start: tt.start, End: tt.end,
+				Progress: func(p loaders.Progress) { last = p },
+				Cut:      func(start, end time.Duration) { cut = &[2]time.Duration{start, end} }}
+			if err := l.Get(context.Background(), job); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Get() wrote %q, want %q", got, tt.want)
+			}
+			if want := time.Duration(len(tt.want)/len("segment-0;")) * 10 * time.Second; last.Duration != want {
+				t.Errorf("Get() reported duration %v, want %v", last.Duration, want)
+			}
+			if !reflect.DeepEqual(cut, tt.wantCut) {
+				t.Errorf("Get() reported cut %v, want %v", cut, tt.wantCut)
+			}
+		})
+	}
+}
+
+func TestLoader_Compatible(t *testing.T) {
+	copyProfile := loaders.Profile{Codec: loaders.CodecCopy}
+	tests := []struct {
+		name string
+		job  loaders.Job
+		want bool
+	}{
+		{"mp3 copy", loaders.Job{Format: parsers.Format{Protocol: "hls", Codec: "mp3", Type: "mpeg"}, Profile: copyProfile}, true},
+		{"opus copy", loaders.Job{Format: parsers.Format{Protocol: "hls", Codec: "opus", Type: "ogg"}, Profile: copyProfile}, true},
+		{"transcoding", loaders.Job{Format: parsers.Format{Protocol: "hls", Codec: "mp3", Type: "mpeg"}, Profile: loaders.DefaultProfile}, false},
+		{"progressive", loaders.Job{Format: parsers.Format{Protocol: "progressive", Codec: "mp3", Type: "mpeg"}, Profile: copyProfile}, false},
+		{"mpeg-ts aac", loaders.Job{Format: parsers.Format{Protocol: "hls", Codec: "aac", Type: "mp2t"}, Profile: copyProfile}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New().Compatible(tt.job); got != tt.want {
+				t.Errorf("Compatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoader_fetchBatch(t *testing.T) {
+	// the last segment fails only after the others are requested, so they
+	// are cancelled because of it
+	const siblings = 3
+	started := make(chan struct{}, siblings)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key.bin":
+			_, _ = w.Write(testKey)
+		case "/broken.ts", "/forbidden.ts":
+			for i := 0; i < siblings; i++ {
+				<-started
+			}
+			if r.URL.Path == "/forbidden.ts" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte("short"))
+		default:
+			started <- struct{}{}
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+	l := loader{name: "hls", client: srv.Client(), workers: defaultWorkers, retries: 1}
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(srv.URL + s)
+		return u
+	}
+
+	tests := []struct {
+		name    string
+		failing segment
+		want    string
+	}{
+		{"http error", segment{uri: parse("/forbidden.ts")}, "unexpected status code 403"},
+		{"decrypt error", segment{uri: parse("/broken.ts"), key: &key{method: "AES-128", uri: parse("/key.bin")}},
+			"isn't multiple of block size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var segments []segment
+			for i := 0; i < siblings; i++ {
+				segments = append(segments, segment{uri: parse(fmt.Sprintf("/%d.ts", i))})
+			}
+			segments = append(segments, tt.failing)
+			_, err := l.fetchBatch(context.Background(), segments, newKeyCache(l))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("fetchBatch() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	segments := []segment{{uri: parse("/0.ts")}, {uri: parse("/1.ts")}}
+	if _, err := l.fetchBatch(ctx, segments, newKeyCache(l)); err != context.Canceled {
+		t.Errorf("fetchBatch() of cancelled context error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,265 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPlaylist = errors.New("invalid m3u8 playlist")
+
+// playlist is a parsed m3u8 file. Master playlists contain only variants,
+// media playlists - only segments
+type playlist struct {
+	variants []variant
+	segments []segment
+	// init is an EXT-X-MAP section, needed for fragmented mp4 streams
+	init *segment
+}
+
+type variant struct {
+	uri       *url.URL
+	bandwidth int
+}
+
+type segment struct {
+	uri      *url.URL
+	duration time.Duration
+	key      *key
+	// sequence is a media sequence number, used as IV if key doesn't have one
+	sequence int
+	// offset and length of sub-range of resource. 0 length means whole resource
+	offset int64
+	length int64
+}
+
+type key struct {
+	method string
+	uri    *url.URL
+	iv     []byte
+}
+
+func (p playlist) isMaster() bool {
+	return len(p.variants) > 0
+}
+
+// duration return total duration of segments
+func duration(segments []segment) time.Duration {
+	var d time.Duration
+	for _, s := range segments {
+		d += s.duration
+	}
+	return d
+}
+
+// slice return media segments, which overlap part of playlist between start
+// and end, and bounds of part they cover. End 0 means the end of playlist
+func (p playlist) slice(start time.Duration, end time.Duration) (segments []segment, from time.Duration, to time.Duration) {
+	var position time.Duration
+	for i, s := range p.segments {
+		if position+s.duration > start && (end < 1 || position < end) {
+			if len(segments) < 1 {
+				from = position
+			}
+			segments = append(segments, s)
+			to = position + s.duration
+			if i == len(p.segments)-1 {
+				// part lasts till the end of playlist
+				to = 0
+			}
+		}
+		position += s.duration
+	}
+	return segments, from, to
+}
+
+// bestVariant return variant with the highest bandwidth
+func (p playlist) bestVariant() variant {
+	best := p.variants[0]
+	for _, v := range p.variants[1:] {
+		if v.bandwidth > best.bandwidth {
+			best = v
+		}
+	}
+	return best
+}
+
+// parsePlaylist parse m3u8 playlist. Relative uris are resolved against base
+func parsePlaylist(data []byte, base *url.URL) (*playlist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, ErrInvalidPlaylist
+	}
+
+	p := new(playlist)
+	var (
+		sequence    int
+		duration    time.Duration
+		currentKey  *key
+		byteRange   string
+		nextVariant *variant
+		// end of previous sub-range, used when byte range doesn't have offset
+		rangeEnd int64
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) < 1:
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				value = value[:i]
+			}
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad segment duration %q", ErrInvalidPlaylist, value)
+			}
+			duration = time.Duration(seconds * float64(time.Second))
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			byteRange = strings.TrimPrefix(line, "#EXT-X-BYTERANGE:")
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			k, err := parseKey(attrs, base)
+			if err != nil {
+				return nil, err
+			}
+			currentKey = k
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			uri, err := base.Parse(attrs["URI"])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad map uri", ErrInvalidPlaylist)
+			}
+			p.init = &segment{uri: uri, key: currentKey}
+			if r, ok := attrs["BYTERANGE"]; ok {
+				if p.init.offset, p.init.length, err = parseByteRange(r, 0); err != nil {
+					return nil, err
+				}
+			}
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			nextVariant = &variant{bandwidth: bandwidth}
+		case strings.HasPrefix(line, "#"):
+			// unsupported tag or comment
+			continue
+		default:
+			uri, err := base.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad uri %q", ErrInvalidPlaylist, line)
+			}
+			if nextVariant != nil {
+				nextVariant.uri = uri
+				p.variants = append(p.variants, *nextVariant)
+				nextVariant = nil
+				continue
+			}
+			seg := segment{
+				uri:      uri,
+				duration: duration,
+				key:      currentKey,
+				sequence: sequence,
+			}
+			if len(byteRange) > 0 {
+				if seg.offset, seg.length, err = parseByteRange(byteRange, rangeEnd); err != nil {
+					return nil, err
+				}
+				rangeEnd = seg.offset + seg.length
+			}
+			p.segments = append(p.segments, seg)
+			sequence++
+			duration = 0
+			byteRange = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.variants) < 1 && len(p.segments) < 1 {
+		return nil, ErrInvalidPlaylist
+	}
+	return p, nil
+}
+
+// parseByteRange parse "length[@offset]". When offset is omitted,
+// sub-range starts right after previous one
+func parseByteRange(s string, previousEnd int64) (offset int64, length int64, err error) {
+	parts := strings.SplitN(s, "@", 2)
+	if length, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("%w: bad byte range %q", ErrInvalidPlaylist, s)
+	}
+	if len(parts) < 2 {
+		return previousEnd, length, nil
+	}
+	if offset, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("%w: bad byte range %q", ErrInvalidPlaylist, s)
+	}
+	return offset, length, nil
+}
+
+func parseKey(attrs map[string]string, base *url.URL) (*key, error) {
+	method := attrs["METHOD"]
+	switch method {
+	case "NONE", "":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("encryption method %s not supported", method)
+	}
+	uri, err := base.Parse(attrs["URI"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad key uri", ErrInvalidPlaylist)
+	}
+	k := &key{method: method, uri: uri}
+	if iv, ok := attrs["IV"]; ok {
+		iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		decoded, err := hex.DecodeString(iv)
+		if err != nil || len(decoded) != 16 {
+			return nil, fmt.Errorf("%w: bad key iv", ErrInvalidPlaylist)
+		}
+		k.iv = decoded
+	}
+	return k, nil
+}
+
+// parseAttributes parse attribute list like `METHOD=AES-128,URI="key.bin"`
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		attrs[name] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
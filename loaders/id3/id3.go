@@ -0,0 +1,482 @@
+// Package id3 read and write ID3v2 tags of mp3 files without any
+// external tools. Tags are always written as ID3v2.4 with UTF-8 text
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerSize = 10
+	// version of written tags
+	majorVersion = 4
+
+	encodingLatin1 = 0
+	encodingUTF16  = 1
+	encodingUTF8   = 3
+
+	flagUnsync = 0x80
+	flagExtHdr = 0x40
+	flagFooter = 0x10
+)
+
+// PictureFrontCover is APIC picture type for front cover
+const PictureFrontCover = 3
+
+var ErrInvalidTag = errors.New("invalid id3 tag")
+
+// Frame is a single raw ID3v2 frame
+type Frame struct {
+	ID   string
+	Data []byte
+}
+
+// Tag is an ordered set of ID3v2 frames
+type Tag struct {
+	Frames []Frame
+}
+
+// New return empty tag
+func New() *Tag {
+	return &Tag{}
+}
+
+// ReadFile read ID3v2 tag from the beginning of file.
+// Return empty tag if file doesn't have one
+func ReadFile(filename string) (*Tag, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read ID3v2 tag from r. Return empty tag if there is no tag
+func Read(r io.Reader) (*Tag, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return New(), nil
+		}
+		return nil, err
+	}
+	if string(header[:3]) != "ID3" {
+		return New(), nil
+	}
+	version := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrInvalidTag
+	}
+	if version < 3 || version > 4 || flags&flagUnsync != 0 {
+		// ancient or unsynchronised tags are too rare to bother,
+		// so they are just replaced
+		return New(), nil
+	}
+	if flags&flagExtHdr != 0 && len(body) >= 4 {
+		var extSize int
+		if version == 4 {
+			extSize = syncsafe(body[:4])
+		} else {
+			extSize = int(binary.BigEndian.Uint32(body[:4])) + 4
+		}
+		if extSize > len(body) {
+			return nil, ErrInvalidTag
+		}
+		body = body[extSize:]
+	}
+
+	t := New()
+	for len(body) >= headerSize {
+		id := string(body[:4])
+		if body[0] == 0 {
+			// padding
+			break
+		}
+		var frameSize int
+		if version == 4 {
+			frameSize = syncsafe(body[4:8])
+		} else {
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		frameFlags := body[8:10]
+		body = body[headerSize:]
+		if frameSize > len(body) {
+			return nil, ErrInvalidTag
+		}
+		data := body[:frameSize]
+		body = body[frameSize:]
+		if frameFlags[0] != 0 || frameFlags[1] != 0 || deprecated(id) {
+			// frames with compression, encryption, etc. or the ones removed
+			// from ID3v2.4 can't be safely copied, so skip them
+			continue
+		}
+		t.Frames = append(t.Frames, Frame{ID: id, Data: append([]byte(nil), data...)})
+	}
+	return t, nil
+}
+
+// WriteFile replace existing ID3v2 tag of file with t
+func WriteFile(filename string, t *Tag) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	offset, err := tagEnd(src)
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".id3-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(t.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// temporary file is created with 0600, while rewritten file should
+	// stay as accessible as it was
+	if err := os.Chmod(tmp.Name(), stat.Mode().Perm()); err != nil {
+		return err
+	}
+	_ = src.Close()
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Bytes return encoded tag with header
+func (t *Tag) Bytes() []byte {
+	var body bytes.Buffer
+	for _, f := range t.Frames {
+		body.Write(f.Bytes())
+	}
+	var buf bytes.Buffer
+	buf.WriteString("ID3")
+	buf.Write([]byte{majorVersion, 0, 0})
+	buf.Write(toSyncsafe(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+// Bytes return encoded frame with header
+func (f Frame) Bytes() []byte {
+	data := make([]byte, 0, headerSize+len(f.Data))
+	data = append(data, f.ID...)
+	data = append(data, toSyncsafe(len(f.Data))...)
+	data = append(data, 0, 0)
+	return append(data, f.Data...)
+}
+
+// Get return data of first frame with given id
+func (t *Tag) Get(id string) ([]byte, bool) {
+	for _, f := range t.Frames {
+		if f.ID == id {
+			return f.Data, true
+		}
+	}
+	return nil, false
+}
+
+// Add append frame to tag
+func (t *Tag) Add(id string, data []byte) {
+	t.Frames = append(t.Frames, Frame{ID: id, Data: data})
+}
+
+// Set replace every frame with given id by single new one
+func (t *Tag) Set(id string, data []byte) {
+	t.Remove(id, nil)
+	t.Add(id, data)
+}
+
+// Remove delete frames with given id. If match isn't nil - only frames
+// for which it return true are deleted
+func (t *Tag) Remove(id string, match func(data []byte) bool) {
+	frames := t.Frames[:0]
+	for _, f := range t.Frames {
+		if f.ID == id && (match == nil || match(f.Data)) {
+			continue
+		}
+		frames = append(frames, f)
+	}
+	t.Frames = frames
+}
+
+// SetText set text frame, like TIT2. Multiple values are allowed by ID3v2.4
+func (t *Tag) SetText(id string, values ...string) {
+	if len(values) < 1 {
+		t.Remove(id, nil)
+		return
+	}
+	data := []byte{encodingUTF8}
+	data = append(data, strings.Join(values, "\x00")...)
+	t.Set(id, data)
+}
+
+// Text return value of text frame
+func (t *Tag) Text(id string) string {
+	data, ok := t.Get(id)
+	if !ok || len(data) < 1 {
+		return ""
+	}
+	s, _ := decodeText(data[0], data[1:])
+	return strings.Replace(s, "\x00", "/", -1)
+}
+
+// SetUserText set TXXX frame with given description
+func (t *Tag) SetUserText(desc string, value string) {
+	t.Remove("TXXX", matchDesc(desc, 1))
+	data := []byte{encodingUTF8}
+	data = append(data, desc...)
+	data = append(data, 0)
+	data = append(data, value...)
+	t.Add("TXXX", data)
+}
+
+// SetComment set COMM frame with given language and description
+func (t *Tag) SetComment(lang string, desc string, text string) {
+	t.Remove("COMM", matchDesc(desc, 4))
+	t.Add("COMM", langFrame(lang, desc, text))
+}
+
+// SetLyrics set USLT frame with unsynchronized lyrics
+func (t *Tag) SetLyrics(lang string, desc string, text string) {
+	t.Remove("USLT", matchDesc(desc, 4))
+	t.Add("USLT", langFrame(lang, desc, text))
+}
+
+// SyncedText is a single line of synchronized lyrics
+type SyncedText struct {
+	Time time.Duration
+	Text string
+}
+
+// SetSyncedLyrics set SYLT frame with lyrics, timed in milliseconds
+func (t *Tag) SetSyncedLyrics(lang string, desc string, lines []SyncedText) {
+	t.Remove("SYLT", matchDesc(desc, 6))
+	if len(lang) != 3 {
+		lang = "eng"
+	}
+	data := []byte{encodingUTF8}
+	data = append(data, lang...)
+	// timestamps in milliseconds, content is lyrics
+	data = append(data, 2, 1)
+	data = append(data, desc...)
+	data = append(data, 0)
+	stamp := make([]byte, 4)
+	for _, line := range lines {
+		data = append(data, line.Text...)
+		data = append(data, 0)
+		binary.BigEndian.PutUint32(stamp, uint32(line.Time.Milliseconds()))
+		data = append(data, stamp...)
+	}
+	t.Add("SYLT", data)
+}
+
+// Chapter is a single CHAP frame
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// SetChapters replace chapters with given ones. Chapters are listed in
+// ordered top-level CTOC frame
+func (t *Tag) SetChapters(chapters []Chapter) {
+	t.Remove("CHAP", nil)
+	t.Remove("CTOC", nil)
+	if len(chapters) < 1 {
+		return
+	}
+	if len(chapters) > 255 {
+		// CTOC can't list more
+		chapters = chapters[:255]
+	}
+	// top-level and ordered, number of entries
+	toc := []byte("toc\x00")
+	toc = append(toc, 0x03, byte(len(chapters)))
+	stamp := make([]byte, 4)
+	for i, ch := range chapters {
+		id := "chp" + strconv.Itoa(i)
+		data := append([]byte(id), 0)
+		for _, ms := range []time.Duration{ch.Start, ch.End} {
+			binary.BigEndian.PutUint32(stamp, uint32(ms.Milliseconds()))
+			data = append(data, stamp...)
+		}
+		// byte offsets aren't used
+		data = append(data, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		title := append([]byte{encodingUTF8}, ch.Title...)
+		data = append(data, Frame{ID: "TIT2", Data: title}.Bytes()...)
+		t.Add("CHAP", data)
+		toc = append(toc, id...)
+		toc = append(toc, 0)
+	}
+	t.Add("CTOC", toc)
+}
+
+// SetURL set url link frame, like WOAS
+func (t *Tag) SetURL(id string, link string) {
+	t.Set(id, []byte(link))
+}
+
+// SetPicture set APIC frame with given picture type
+func (t *Tag) SetPicture(mime string, pictureType byte, desc string, picture []byte) {
+	t.Remove("APIC", func(data []byte) bool {
+		// skip encoding and mime
+		i := bytes.IndexByte(data[1:], 0)
+		return i >= 0 && len(data) > i+2 && data[i+2] == pictureType
+	})
+	data := []byte{encodingUTF8}
+	data = append(data, mime...)
+	data = append(data, 0, pictureType)
+	data = append(data, desc...)
+	data = append(data, 0)
+	data = append(data, picture...)
+	t.Add("APIC", data)
+}
+
+// langFrame encode frames with language and description, like COMM or USLT
+func langFrame(lang string, desc string, text string) []byte {
+	if len(lang) != 3 {
+		lang = "eng"
+	}
+	data := []byte{encodingUTF8}
+	data = append(data, lang...)
+	data = append(data, desc...)
+	data = append(data, 0)
+	data = append(data, text...)
+	return data
+}
+
+// matchDesc return matcher of frames with description starting at offset
+func matchDesc(desc string, offset int) func([]byte) bool {
+	return func(data []byte) bool {
+		if len(data) <= offset {
+			return false
+		}
+		s, _ := decodeText(data[0], data[offset:])
+		if i := strings.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return s == desc
+	}
+}
+
+// tagEnd return offset of the first byte after ID3v2 tag
+func tagEnd(r io.ReadSeeker) (int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	end := int64(headerSize + syncsafe(header[6:10]))
+	if header[5]&flagFooter != 0 {
+		end += headerSize
+	}
+	return end, nil
+}
+
+func decodeText(encoding byte, data []byte) (string, error) {
+	switch encoding {
+	case encodingLatin1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return strings.TrimRight(string(runes), "\x00"), nil
+	case encodingUTF16, 2:
+		return strings.TrimRight(decodeUTF16(data, encoding == 2), "\x00"), nil
+	case encodingUTF8:
+		return strings.TrimRight(string(data), "\x00"), nil
+	default:
+		return "", ErrInvalidTag
+	}
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xFF && data[1] == 0xFE:
+			bigEndian = false
+			data = data[2:]
+		case data[0] == 0xFE && data[1] == 0xFF:
+			bigEndian = true
+			data = data[2:]
+		}
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, binary.BigEndian.Uint16(data[i:]))
+		} else {
+			units = append(units, binary.LittleEndian.Uint16(data[i:]))
+		}
+	}
+	var sb strings.Builder
+	for i := 0; i < len(units); i++ {
+		u := units[i]
+		if u >= 0xD800 && u < 0xDC00 && i+1 < len(units) {
+			r := (rune(u)-0xD800)<<10 + (rune(units[i+1]) - 0xDC00) + 0x10000
+			sb.WriteRune(r)
+			i++
+			continue
+		}
+		sb.WriteRune(rune(u))
+	}
+	return sb.String()
+}
+
+// deprecated report whether frame was removed in ID3v2.4
+func deprecated(id string) bool {
+	switch id {
+	case "TYER", "TDAT", "TIME", "TRDA", "TSIZ", "TORY", "EQUA", "IPLS", "RVAD":
+		return true
+	default:
+		return false
+	}
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func toSyncsafe(n int) []byte {
+	return []byte{
+		byte(n>>21) & 0x7f,
+		byte(n>>14) & 0x7f,
+		byte(n>>7) & 0x7f,
+		byte(n) & 0x7f,
+	}
+}
@@ -0,0 +1,133 @@
+package id3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testAudio imitate mp3 frames after tag
+var testAudio = bytes.Repeat([]byte("\xff\xfbaudio;"), 64)
+
+func TestTag_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "id3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "song.mp3")
+	if err := ioutil.WriteFile(filename, testAudio, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	picture := []byte("\xff\xd8\xff\xe0 jpeg")
+	tag := New()
+	tag.SetText("TIT2", "Song")
+	tag.SetPicture("image/jpeg", PictureFrontCover, "Cover (front)", picture)
+	tag.SetLyrics("eng", "", "First line\nSecond line")
+	tag.SetSyncedLyrics("eng", "", []SyncedText{
+		{Time: 1500 * time.Millisecond, Text: "First line"},
+		{Time: 75250 * time.Millisecond, Text: "Second line"},
+	})
+	tag.SetChapters([]Chapter{
+		{Title: "Intro", Start: 0, End: 30 * time.Second},
+		{Title: "Artist - Song", Start: 30 * time.Second, End: 3 * time.Minute},
+	})
+	if err := WriteFile(filename, tag); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Frames, tag.Frames) {
+		t.Errorf("ReadFile() = %q, want %q", got.Frames, tag.Frames)
+	}
+
+	tests := []struct {
+		id   string
+		want []byte
+	}{
+		{"APIC", append([]byte("\x03image/jpeg\x00\x03Cover (front)\x00"), picture...)},
+		{"USLT", []byte("\x03eng\x00First line\nSecond line")},
+		{"SYLT", []byte("\x03eng\x02\x01\x00" +
+			"First line\x00\x00\x00\x05\xdc" +
+			"Second line\x00\x00\x01\x25\xf2")},
+		{"CHAP", []byte("chp0\x00\x00\x00\x00\x00\x00\x00\x75\x30\xff\xff\xff\xff\xff\xff\xff\xff" +
+			"TIT2\x00\x00\x00\x06\x00\x00\x03Intro")},
+		{"CTOC", []byte("toc\x00\x03\x02chp0\x00chp1\x00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			data, ok := got.Get(tt.id)
+			if !ok {
+				t.Fatalf("%s isn't written", tt.id)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("%s = %q, want %q", tt.id, data, tt.want)
+			}
+		})
+	}
+
+	// audio is kept after tag, even if new tag is smaller
+	tag.SetChapters(nil)
+	tag.Remove("APIC", nil)
+	if err := WriteFile(filename, tag); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, append(tag.Bytes(), testAudio...)) {
+		t.Errorf("file isn't made of new tag and original audio")
+	}
+	if got, _ := ReadFile(filename); len(got.Frames) != 3 {
+		t.Errorf("ReadFile() = %q, want 3 frames", got.Frames)
+	}
+
+	// file keeps its permissions
+	for _, mode := range []os.FileMode{0644, 0640} {
+		if err := os.Chmod(filename, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(filename, tag); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Mode().Perm() != mode {
+			t.Errorf("mode after WriteFile() = %v, want %v", stat.Mode().Perm(), mode)
+		}
+	}
+}
+
+func TestTag_Replace(t *testing.T) {
+	tag := New()
+	tag.SetPicture("image/jpeg", PictureFrontCover, "", []byte("old"))
+	tag.SetPicture("image/png", 4, "", []byte("back"))
+	tag.SetPicture("image/png", PictureFrontCover, "", []byte("new"))
+	tag.SetLyrics("eng", "", "old")
+	tag.SetLyrics("eng", "other", "kept")
+	tag.SetLyrics("deu", "", "new")
+	tag.SetChapters([]Chapter{{Title: "One", End: time.Second}, {Title: "Two", Start: time.Second}})
+	tag.SetChapters([]Chapter{{Title: "Only", End: time.Second}})
+
+	var ids []string
+	for _, f := range tag.Frames {
+		ids = append(ids, f.ID)
+	}
+	want := []string{"APIC", "APIC", "USLT", "USLT", "CHAP", "CTOC"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("frames = %v, want %v", ids, want)
+	}
+	if !bytes.HasSuffix(tag.Frames[1].Data, []byte("new")) || !bytes.HasSuffix(tag.Frames[3].Data, []byte("new")) {
+		t.Errorf("frames aren't replaced: %q", tag.Frames)
+	}
+}
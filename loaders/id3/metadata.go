@@ -0,0 +1,139 @@
+package id3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/camelva/erzo/loaders"
+)
+
+// textFrames map common metadata keys (same as ffmpeg's ones) to ID3v2.4 frames
+var textFrames = map[string]string{
+	"title":        "TIT2",
+	"album":        "TALB",
+	"artist":       "TPE1",
+	"album_artist": "TPE2",
+	"composer":     "TCOM",
+	"genre":        "TCON",
+	"track":        "TRCK",
+	"disc":         "TPOS",
+	"date":         "TDRC",
+	"publisher":    "TPUB",
+	"copyright":    "TCOP",
+	"encoded_by":   "TENC",
+	"language":     "TLAN",
+	"isrc":         "TSRC",
+}
+
+// languages map ISO 639-1 codes of common languages to ISO 639-2 ones,
+// used by ID3
+var languages = map[string]string{
+	"ar": "ara", "de": "deu", "en": "eng", "es": "spa", "fr": "fra",
+	"hi": "hin", "it": "ita", "ja": "jpn", "ko": "kor", "nl": "nld",
+	"pl": "pol", "pt": "por", "ru": "rus", "sv": "swe", "tr": "tur",
+	"uk": "ukr", "zh": "zho",
+}
+
+// language convert language code, like "en" or "pt-BR", into ID3 one.
+// Unknown languages are "XXX"
+func language(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if len(code) == 3 {
+		return code
+	}
+	if lang, ok := languages[code]; ok {
+		return lang
+	}
+	return "XXX"
+}
+
+// SetMetadata set frame corresponding to metadata key. Unknown keys are
+// stored as TXXX frames
+func (t *Tag) SetMetadata(key string, value string) {
+	key = strings.ToLower(key)
+	if id, ok := textFrames[key]; ok {
+		t.SetText(id, value)
+		return
+	}
+	switch key {
+	case "comment", "description":
+		t.SetComment("eng", "", value)
+	case "lyrics":
+		t.SetLyrics("XXX", "", value)
+	case "url":
+		// official audio source webpage
+		t.SetURL("WOAS", value)
+	default:
+		t.SetUserText(key, value)
+	}
+}
+
+// SetTags set frames from tags. Multiple artists are stored as
+// multi-valued frame
+func (t *Tag) SetTags(tags loaders.Tags) {
+	for _, f := range tags.Fields() {
+		t.SetMetadata(f.Key, f.Value)
+	}
+	if len(tags.Artists) > 1 {
+		t.SetText(textFrames["artist"], tags.Artists...)
+	}
+	if len(tags.AlbumArtists) > 1 {
+		t.SetText(textFrames["album_artist"], tags.AlbumArtists...)
+	}
+	if len(tags.Chapters) > 0 {
+		chapters := make([]Chapter, 0, len(tags.Chapters))
+		for _, ch := range tags.Chapters {
+			chapters = append(chapters, Chapter{Title: ch.Title, Start: ch.Start, End: ch.End})
+		}
+		t.SetChapters(chapters)
+	}
+	if lyrics := tags.Lyrics; len(lyrics.Lines) > 0 {
+		lang := language(lyrics.Language)
+		t.SetLyrics(lang, "", lyrics.Text())
+		if lyrics.Synced() {
+			lines := make([]SyncedText, 0, len(lyrics.Lines))
+			for _, line := range lyrics.Lines {
+				lines = append(lines, SyncedText{Time: line.Start, Text: line.Text})
+			}
+			t.SetSyncedLyrics(lang, "", lines)
+		}
+	}
+}
+
+// WriteTags update tag of mp3 file
+func WriteTags(filename string, tags loaders.Tags) error {
+	tag, err := readMP3(filename)
+	if err != nil {
+		return err
+	}
+	tag.SetTags(tags)
+	return WriteFile(filename, tag)
+}
+
+// WriteCover embed picture from file thumb as front cover of mp3 file
+func WriteCover(filename string, thumb string) error {
+	tag, err := readMP3(filename)
+	if err != nil {
+		return err
+	}
+	picture, err := ioutil.ReadFile(thumb)
+	if err != nil {
+		return err
+	}
+	mime := http.DetectContentType(picture)
+	tag.SetPicture(mime, PictureFrontCover, "Cover (front)", picture)
+	return WriteFile(filename, tag)
+}
+
+func readMP3(filename string) (*Tag, error) {
+	if ext := path.Ext(filename); strings.ToLower(ext) != ".mp3" {
+		return nil, fmt.Errorf("tags of %s files aren't supported", ext)
+	}
+	return ReadFile(filename)
+}
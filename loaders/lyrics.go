@@ -0,0 +1,114 @@
+package loaders
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// _defaultLineDuration is used for the last line without known duration
+const _defaultLineDuration = 4 * time.Second
+
+// LyricLine is a single line of lyrics
+type LyricLine struct {
+	// Start is an offset from the beginning of song
+	Start time.Duration
+	// Duration of line. 0 means it lasts till the next one
+	Duration time.Duration
+	Text     string
+}
+
+// Lyrics of song. They are embedded as unsynchronized text (USLT for ID3)
+// and, if lines have timings, as synchronized ones (SYLT)
+type Lyrics struct {
+	// Language code, like "en"
+	Language string
+	Lines    []LyricLine
+}
+
+// Synced report whether lines have timings
+func (l Lyrics) Synced() bool {
+	for _, line := range l.Lines {
+		if line.Start > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Text return lyrics without timings, line by line
+func (l Lyrics) Text() string {
+	lines := make([]string, 0, len(l.Lines))
+	for _, line := range l.Lines {
+		lines = append(lines, line.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SRT return lyrics in SubRip format
+func (l Lyrics) SRT() string {
+	var b strings.Builder
+	for i, line := range l.Lines {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatTimestamp(line.Start, ","), formatTimestamp(l.end(i), ","), line.Text)
+	}
+	return b.String()
+}
+
+// VTT return lyrics in WebVTT format
+func (l Lyrics) VTT() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i, line := range l.Lines {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n",
+			formatTimestamp(line.Start, "."), formatTimestamp(l.end(i), "."), line.Text)
+	}
+	return b.String()
+}
+
+// LRC return lyrics in LRC format. Multi-line texts are joined by spaces
+func (l Lyrics) LRC() string {
+	var b strings.Builder
+	if len(l.Language) > 0 {
+		fmt.Fprintf(&b, "[la:%s]\n", l.Language)
+	}
+	for _, line := range l.Lines {
+		centiseconds := line.Start.Milliseconds() / 10
+		fmt.Fprintf(&b, "[%02d:%02d.%02d]%s\n",
+			centiseconds/6000, centiseconds/100%60, centiseconds%100, strings.Join(strings.Fields(line.Text), " "))
+	}
+	return b.String()
+}
+
+// end return the end of i-th line
+func (l Lyrics) end(i int) time.Duration {
+	line := l.Lines[i]
+	if line.Duration > 0 {
+		return line.Start + line.Duration
+	}
+	if i+1 < len(l.Lines) && l.Lines[i+1].Start > line.Start {
+		return l.Lines[i+1].Start
+	}
+	return line.Start + _defaultLineDuration
+}
+
+// formatTimestamp format d like "01:02:03,456" with given separator of
+// milliseconds
+func formatTimestamp(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// Format return lyrics in format with given extension: "srt", "vtt" or "lrc"
+func (l Lyrics) Format(ext string) (string, error) {
+	switch strings.ToLower(ext) {
+	case "srt":
+		return l.SRT(), nil
+	case "vtt":
+		return l.VTT(), nil
+	case "lrc":
+		return l.LRC(), nil
+	}
+	return "", fmt.Errorf("unknown lyrics format %s", strconv.Quote(ext))
+}
@@ -1,7 +1,9 @@
 package loaders
 
 import (
+	"context"
 	"net/url"
+	"time"
 
 	"github.com/camelva/erzo/parsers"
 )
@@ -9,8 +11,67 @@ import (
 type Loader interface {
 	Name() string
 	Bin() string
-	Compatible(format parsers.Format) bool
-	Get(*url.URL, string) error
-	UpdateTags(string, []string) error
-	AddThumbnail(string, string) error
+	Compatible(job Job) bool
+	Get(context.Context, Job) error
+	UpdateTags(context.Context, string, Tags) error
+	AddThumbnail(context.Context, string, string) error
+}
+
+// Splitter is implemented by loaders, which can cut part of file into
+// separate file without re-encoding, like chapter of song. Output has the
+// same format as input. End 0 means the end of file
+type Splitter interface {
+	Split(ctx context.Context, filename string, output string, start time.Duration, end time.Duration) error
+}
+
+// Job describe single download for Loader
+type Job struct {
+	URL *url.URL
+	// Format is a source format, URL belongs to
+	Format parsers.Format
+	// Output is a path of resulting file. Its extension match Profile
+	Output  string
+	Profile Profile
+	// Duration is an expected length of media, if known
+	Duration time.Duration
+	// Progress receive downloading updates. Can be nil
+	Progress ProgressFunc
+	// Start and End limit downloaded part of media. End 0 means the end of
+	// media. Loaders, which can't cut precisely, may keep a bit more and
+	// report part they actually downloaded to Cut
+	Start time.Duration
+	End   time.Duration
+	// Cut receive bounds of actually downloaded part, when it's wider than
+	// requested one. Can be nil
+	Cut CutFunc
+}
+
+// CutFunc receive bounds of downloaded part of media. End 0 means the end
+// of media
+type CutFunc func(start time.Duration, end time.Duration)
+
+// ReportCut send bounds of downloaded part to job's CutFunc, if there is
+// one and they differ from requested ones
+func (j Job) ReportCut(start time.Duration, end time.Duration) {
+	if j.Cut == nil || (start == j.Start && end == j.End) {
+		return
+	}
+	j.Cut(start, end)
+}
+
+// Trimmed report whether only part of media is requested
+func (j Job) Trimmed() bool {
+	return j.Start > 0 || j.End > 0
+}
+
+// Length return expected length of downloaded part. 0 means unknown
+func (j Job) Length() time.Duration {
+	end := j.End
+	if end < 1 {
+		end = j.Duration
+	}
+	if end <= j.Start {
+		return 0
+	}
+	return end - j.Start
 }
@@ -0,0 +1,53 @@
+package loaders
+
+// Codecs, supported by output Profile
+const (
+	CodecMP3    = "mp3"
+	CodecOpus   = "opus"
+	CodecAAC    = "aac"
+	CodecFLAC   = "flac"
+	CodecVorbis = "vorbis"
+	// CodecCopy keep original stream without re-encoding
+	CodecCopy = "copy"
+)
+
+// Profile describe codec and quality of output file
+type Profile struct {
+	Codec string
+	// Bitrate in kbit/s for lossy codecs. 0 means codec's default
+	Bitrate int
+	// VBR enable variable bitrate mode. In this case Quality is used
+	// instead of Bitrate, if codec support it
+	VBR bool
+	// Quality is codec-specific quality level (for example -q:a for mp3 and
+	// vorbis). For flac it is compression level
+	Quality int
+}
+
+// DefaultProfile is used when no other profile provided
+var DefaultProfile = Profile{Codec: CodecMP3, Bitrate: 128}
+
+// Ext return extension of container, matching profile's codec. For CodecCopy
+// return empty string, because container depends on source stream
+func (p Profile) Ext() string {
+	return ExtForCodec(p.Codec)
+}
+
+// ExtForCodec return extension of container, which suits codec best.
+// Return empty string for unknown codecs
+func ExtForCodec(codec string) string {
+	switch codec {
+	case CodecMP3:
+		return "mp3"
+	case CodecOpus:
+		return "opus"
+	case CodecAAC:
+		return "m4a"
+	case CodecFLAC:
+		return "flac"
+	case CodecVorbis:
+		return "ogg"
+	default:
+		return ""
+	}
+}
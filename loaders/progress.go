@@ -0,0 +1,76 @@
+package loaders
+
+import "time"
+
+// Progress is a downloading progress, reported by loader
+type Progress struct {
+	// Bytes already written. TotalBytes is 0 if total size is unknown
+	Bytes      int64
+	TotalBytes int64
+	// Time is a position of already processed media.
+	// Duration is 0 if total length is unknown
+	Time     time.Duration
+	Duration time.Duration
+}
+
+// ProgressFunc receive downloading progress updates
+type ProgressFunc func(Progress)
+
+// Report send progress to job's ProgressFunc, if there is one
+func (j Job) Report(p Progress) {
+	if j.Progress == nil {
+		return
+	}
+	if p.Duration == 0 {
+		p.Duration = j.Length()
+	}
+	j.Progress(p)
+}
+
+// Ratio return completed part of download in range [0, 1], based on bytes
+// or, if total size is unknown, on media time. Return -1 if both are unknown
+func (p Progress) Ratio() float64 {
+	var r float64
+	switch {
+	case p.TotalBytes > 0:
+		r = float64(p.Bytes) / float64(p.TotalBytes)
+	case p.Duration > 0:
+		r = float64(p.Time) / float64(p.Duration)
+	default:
+		return -1
+	}
+	if r > 1 {
+		return 1
+	}
+	return r
+}
+
+// reportInterval is a minimal delay between two reports of ProgressWriter
+const reportInterval = 200 * time.Millisecond
+
+// ProgressWriter count bytes written through it and report them to job.
+// Reports are throttled, call Flush to send the final one
+type ProgressWriter struct {
+	job  Job
+	p    Progress
+	last time.Time
+}
+
+// NewProgressWriter return writer, which start counting from offset bytes
+func NewProgressWriter(job Job, offset int64, total int64) *ProgressWriter {
+	return &ProgressWriter{job: job, p: Progress{Bytes: offset, TotalBytes: total}}
+}
+
+func (w *ProgressWriter) Write(b []byte) (int, error) {
+	w.p.Bytes += int64(len(b))
+	if now := time.Now(); now.Sub(w.last) >= reportInterval {
+		w.last = now
+		w.job.Report(w.p)
+	}
+	return len(b), nil
+}
+
+// Flush report current progress immediately
+func (w *ProgressWriter) Flush() {
+	w.job.Report(w.p)
+}
@@ -0,0 +1,238 @@
+// Package progressive implement loader, which download files over http
+// as is, without any external tools. It can't transcode, so it's used
+// only when source stream can be copied into output file directly
+package progressive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/camelva/erzo/engine"
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/loaders/id3"
+	"github.com/camelva/erzo/utils"
+)
+
+// maxRetries is a number of attempts to resume interrupted download
+const maxRetries = 3
+
+type loader struct {
+	name      string
+	protocols []string
+	client    *http.Client
+}
+
+func init() {
+	engine.AddLoader(New())
+}
+
+// New return progressive loader instance
+func New() loaders.Loader {
+	return loader{
+		name:      "progressive",
+		protocols: []string{"http", "https", "progressive"},
+		client:    http.DefaultClient,
+	}
+}
+
+func (l loader) Name() string {
+	return l.name
+}
+
+// Bin return empty string, because loader doesn't need any binary
+func (l loader) Bin() string {
+	return ""
+}
+
+// Compatible report whether source file can be saved as is. It's only
+// possible when profile asks to copy stream of mp3 file, because loader
+// can't tag other containers. Trimmed jobs need known duration, so file
+// can be cut at estimated bytes
+func (l loader) Compatible(job loaders.Job) bool {
+	if job.Profile.Codec != loaders.CodecCopy || job.Format.Codec != loaders.CodecMP3 {
+		return false
+	}
+	if job.Trimmed() && job.Duration < 1 {
+		return false
+	}
+	if job.Format.Ext != loaders.ExtForCodec(job.Format.Codec) {
+		return false
+	}
+	for _, p := range l.protocols {
+		if p == job.Format.Protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// Get download file into job.Output. Interrupted downloads are resumed
+// with Range requests
+func (l loader) Get(ctx context.Context, job loaders.Job) error {
+	from, to, err := l.byteRange(ctx, job)
+	if err != nil {
+		return err
+	}
+	partName := job.Output + ".part"
+	for i := 0; i < maxRetries; i++ {
+		if err = l.download(ctx, job, partName, from, to); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			// don't leave partially written file after cancellation
+			_ = os.Remove(partName)
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			_ = os.Remove(partName)
+			return ctx.Err()
+		case <-time.After(time.Duration(i+1) * time.Second):
+		}
+	}
+	if err != nil {
+		_ = os.Remove(partName)
+		return err
+	}
+	return os.Rename(partName, job.Output)
+}
+
+// byteRange estimate bytes of trimmed part of file, assuming constant
+// bitrate. To is exclusive, 0 means the end of file
+func (l loader) byteRange(ctx context.Context, job loaders.Job) (from int64, to int64, err error) {
+	if !job.Trimmed() {
+		return 0, 0, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, job.URL.String(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("User-Agent", utils.UserAgent)
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 1 {
+		return 0, 0, fmt.Errorf("can't trim file of unknown size")
+	}
+	size := resp.ContentLength
+	position := func(d time.Duration) int64 {
+		return int64(float64(size) * float64(d) / float64(job.Duration))
+	}
+	from = position(job.Start)
+	if job.End > job.Start && job.End < job.Duration {
+		to = position(job.End)
+	}
+	return from, to, nil
+}
+
+// download fetch bytes [from, to) of job's url into file. To 0 means the end
+// of file. If file already exist - its download is resumed from the last byte
+func (l loader) download(ctx context.Context, job loaders.Job, filename string, from int64, to int64) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", utils.UserAgent)
+	if to > 0 && from+offset >= to {
+		// part is already complete
+		return nil
+	}
+	if from+offset > 0 || to > 0 {
+		end := ""
+		if to > 0 {
+			end = strconv.FormatInt(to-1, 10)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", from+offset, end))
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// server ignored range, start from scratch
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		offset = 0
+		if _, err := io.CopyN(ioutil.Discard, body, from); err != nil {
+			return err
+		}
+	case http.StatusPartialContent:
+		if start := rangeStart(resp.Header.Get("Content-Range")); start != from+offset {
+			return fmt.Errorf("server returned unexpected range: %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// file is already complete
+		return nil
+	default:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var total int64
+	if to > 0 {
+		total = to - from
+		body = io.LimitReader(body, total-offset)
+	} else if resp.ContentLength > 0 {
+		total = offset + resp.ContentLength
+		if resp.StatusCode == http.StatusOK {
+			total -= from
+		}
+	}
+	progress := loaders.NewProgressWriter(job, offset, total)
+	if _, err := io.Copy(io.MultiWriter(f, progress), body); err != nil {
+		return err
+	}
+	progress.Flush()
+	return nil
+}
+
+// UpdateTags write tags into file. Only mp3 files are supported
+func (l loader) UpdateTags(ctx context.Context, filename string, tags loaders.Tags) error {
+	return id3.WriteTags(filename, tags)
+}
+
+// AddThumbnail embed cover into file. Only mp3 files are supported
+func (l loader) AddThumbnail(ctx context.Context, filename string, thumb string) error {
+	return id3.WriteCover(filename, thumb)
+}
+
+// rangeStart return first byte position from Content-Range header,
+// like "bytes 100-200/1000". Return -1 if header is invalid
+func rangeStart(contentRange string) int64 {
+	s := strings.TrimPrefix(contentRange, "bytes ")
+	i := strings.IndexByte(s, '-')
+	if i < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
@@ -0,0 +1,143 @@
+package progressive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/loaders"
+	"github.com/camelva/erzo/parsers"
+)
+
+// testFile is served by test server. It lasts 10 seconds, so every second
+// is 100 bytes
+var testFile = bytes.Repeat([]byte("0123456789"), 100)
+
+// newServer serve testFile and remember Range headers of GET requests.
+// Server without ranges support always respond with the whole file
+func newServer(ranges bool) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			requested = append(requested, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		if ranges {
+			http.ServeContent(w, r, "song.mp3", time.Time{}, bytes.NewReader(testFile))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(testFile)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(testFile)
+		}
+	}))
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, requested...)
+	}
+}
+
+func TestLoader_Get(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progressive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		ranges bool
+		// part is a content of partial file left by previous attempt
+		part  []byte
+		start time.Duration
+		end   time.Duration
+		want  []byte
+		// requests are Range headers of sent requests
+		requests []string
+	}{
+		{"fresh", true, nil, 0, 0, testFile, []string{""}},
+		{"resume", true, testFile[:300], 0, 0, testFile, []string{"bytes=300-"}},
+		{"resume without ranges", false, []byte("stale"), 0, 0, testFile, []string{"bytes=5-"}},
+		{"complete part", true, testFile, 0, 0, testFile, []string{"bytes=1000-"}},
+		{"trimmed", true, nil, 2 * time.Second, 5 * time.Second, testFile[200:500], []string{"bytes=200-499"}},
+		{"trimmed resume", true, testFile[200:250], 2 * time.Second, 0, testFile[200:], []string{"bytes=250-"}},
+		{"trimmed without ranges", false, nil, 2 * time.Second, 5 * time.Second, testFile[200:500], []string{"bytes=200-499"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := newServer(tt.ranges)
+			defer srv.Close()
+			out := filepath.Join(dir, "out.mp3")
+			if tt.part != nil {
+				if err := ioutil.WriteFile(out+".part", tt.part, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u, _ := url.Parse(srv.URL + "/song.mp3")
+			var last loaders.Progress
+			job := loaders.Job{URL: u, Output: out, Duration: 10 * time.Second, Start: tt.start, End: tt.end,
+				Progress: func(p loaders.Progress) { last = p }}
+			l := loader{name: "progressive", protocols: []string{"progressive"}, client: srv.Client()}
+			if err := l.Get(context.Background(), job); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Get() wrote %d bytes, want %d", len(got), len(tt.want))
+			}
+			if r := requests(); !reflect.DeepEqual(r, tt.requests) {
+				t.Errorf("requested ranges %q, want %q", r, tt.requests)
+			}
+			// nothing is downloaded, when part is already complete
+			if !bytes.Equal(tt.part, tt.want) && last.Bytes != int64(len(tt.want)) {
+				t.Errorf("reported %d bytes, want %d", last.Bytes, len(tt.want))
+			}
+			if _, err := os.Stat(out + ".part"); !os.IsNotExist(err) {
+				t.Errorf("partial file is left")
+			}
+		})
+	}
+}
+
+func TestLoader_Compatible(t *testing.T) {
+	copyProfile := loaders.Profile{Codec: loaders.CodecCopy}
+	mp3 := parsers.Format{Protocol: "progressive", Codec: "mp3", Ext: "mp3"}
+	tests := []struct {
+		name string
+		job  loaders.Job
+		want bool
+	}{
+		{"mp3 copy", loaders.Job{Format: mp3, Profile: copyProfile}, true},
+		{"transcoding", loaders.Job{Format: mp3, Profile: loaders.DefaultProfile}, false},
+		{"m4a copy", loaders.Job{Format: parsers.Format{Protocol: "https", Codec: "aac", Ext: "m4a"}, Profile: copyProfile}, false},
+		{"opus copy", loaders.Job{Format: parsers.Format{Protocol: "https", Codec: "opus", Ext: "opus"}, Profile: copyProfile}, false},
+		{"mismatched container", loaders.Job{Format: parsers.Format{Protocol: "http", Codec: "mp3", Ext: "mp4"}, Profile: copyProfile}, false},
+		{"hls", loaders.Job{Format: parsers.Format{Protocol: "hls", Codec: "mp3", Ext: "mp3"}, Profile: copyProfile}, false},
+		{"trimmed", loaders.Job{Format: mp3, Profile: copyProfile, Duration: time.Minute, Start: time.Second}, true},
+		{"trimmed of unknown duration", loaders.Job{Format: mp3, Profile: copyProfile, Start: time.Second}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New().Compatible(tt.job); got != tt.want {
+				t.Errorf("Compatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package loaders
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags is a song's metadata, written into output file. Every loader
+// translate it into format of its container. Empty fields aren't written
+type Tags struct {
+	Title        string
+	Artists      []string
+	Album        string
+	AlbumArtists []string
+	Genre        string
+	// Track is a position in album. 0 means unknown
+	Track int
+	// Date of release, like "2020" or "2020-02-23"
+	Date    string
+	Comment string
+	// Publisher is a record label
+	Publisher string
+	ISRC      string
+	// URL is a page of song
+	URL string
+	// Lyrics of song. Loaders, which can't store synchronized lyrics,
+	// write them as plain text
+	Lyrics Lyrics
+	// Chapters are markers inside file (CHAP and CTOC for ID3)
+	Chapters []Chapter
+	// Custom contain any other fields, like "mood" or "bpm". They are
+	// written as user-defined frames (TXXX for ID3)
+	Custom map[string]string
+}
+
+// Chapter is a named part of file
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Field is a single metadata entry. Keys are the same as ffmpeg's ones
+type Field struct {
+	Key   string
+	Value string
+}
+
+// ArtistSeparator join multiple artists for containers, which can't store
+// multi-valued fields
+const ArtistSeparator = "; "
+
+// Fields return every non-empty field in stable order: standard ones
+// first, then custom ones sorted by key
+func (t Tags) Fields() []Field {
+	var track string
+	if t.Track > 0 {
+		track = strconv.Itoa(t.Track)
+	}
+	standard := []Field{
+		{"title", t.Title},
+		{"artist", strings.Join(t.Artists, ArtistSeparator)},
+		{"album", t.Album},
+		{"album_artist", strings.Join(t.AlbumArtists, ArtistSeparator)},
+		{"genre", t.Genre},
+		{"track", track},
+		{"date", t.Date},
+		{"comment", t.Comment},
+		{"publisher", t.Publisher},
+		{"isrc", t.ISRC},
+		{"url", t.URL},
+		{"lyrics", t.Lyrics.Text()},
+	}
+	fields := make([]Field, 0, len(standard)+len(t.Custom))
+	for _, f := range standard {
+		if len(f.Value) > 0 {
+			fields = append(fields, f)
+		}
+	}
+	keys := make([]string, 0, len(t.Custom))
+	for key := range t.Custom {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := t.Custom[key]; len(value) > 0 {
+			fields = append(fields, Field{key, value})
+		}
+	}
+	return fields
+}
+
+// SetCustom set custom field, creating map if needed
+func (t *Tags) SetCustom(key string, value string) {
+	if t.Custom == nil {
+		t.Custom = make(map[string]string)
+	}
+	t.Custom[key] = value
+}
@@ -1,8 +1,13 @@
 package erzo
 
 import (
+	"context"
+
 	"github.com/camelva/erzo/engine"
 	_ "github.com/camelva/erzo/loaders/ffmpeg"
+	_ "github.com/camelva/erzo/loaders/hls"
+	_ "github.com/camelva/erzo/loaders/progressive"
+	"github.com/camelva/erzo/parsers"
 	_ "github.com/camelva/erzo/parsers/soundcloud"
 	_ "github.com/camelva/erzo/parsers/youtube"
 )
@@ -21,11 +26,17 @@ import (
 // ErrDownloadingError if fatal error occurred while downloading song
 // ErrUndefined any other errors
 func Get(message string, opts ...Option) (*engine.SongResult, error) {
-	song, err := GetInfo(message, opts...)
+	return GetContext(context.Background(), message, opts...)
+}
+
+// GetContext same as Get, but whole process will be aborted when ctx is done.
+// In this case ErrCancelled returned and partially downloaded files are removed
+func GetContext(ctx context.Context, message string, opts ...Option) (*engine.SongResult, error) {
+	song, err := GetInfoContext(ctx, message, opts...)
 	if err != nil {
 		return nil, err
 	}
-	songRes, err := song.Get()
+	songRes, err := song.GetContext(ctx)
 	if err != nil {
 		return nil, convertErr(err)
 	}
@@ -33,6 +44,126 @@ func Get(message string, opts ...Option) (*engine.SongResult, error) {
 }
 
 func GetInfo(message string, opts ...Option) (*engine.SongInfo, error) {
+	return GetInfoContext(context.Background(), message, opts...)
+}
+
+// GetInfoContext same as GetInfo, but extracting will be aborted when ctx is done
+func GetInfoContext(ctx context.Context, message string, opts ...Option) (*engine.SongInfo, error) {
+	e := newEngine(opts...)
+	song, err := e.GetInfoContext(ctx, message)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return song, nil
+}
+
+// GetCollection process given multi-track url (like playlist) and download
+// every song from it. Accept same options as Get.
+// Return one of Get's errors if collection itself can't be processed.
+// Errors of certain songs are returned inside CollectionResult.Entries
+func GetCollection(message string, opts ...Option) (*engine.CollectionResult, error) {
+	return GetCollectionContext(context.Background(), message, opts...)
+}
+
+// GetCollectionContext same as GetCollection, but whole process will be
+// aborted when ctx is done. Already downloaded songs are returned with ErrCancelled
+func GetCollectionContext(ctx context.Context, message string, opts ...Option) (*engine.CollectionResult, error) {
+	collection, err := GetCollectionInfoContext(ctx, message, opts...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := collection.GetContext(ctx)
+	if result != nil {
+		for i := range result.Entries {
+			if result.Entries[i].Err != nil {
+				result.Entries[i].Err = convertErr(result.Entries[i].Err)
+			}
+		}
+	}
+	if err != nil {
+		return result, convertErr(err)
+	}
+	return result, nil
+}
+
+func GetCollectionInfo(message string, opts ...Option) (*engine.CollectionInfo, error) {
+	return GetCollectionInfoContext(context.Background(), message, opts...)
+}
+
+// GetCollectionInfoContext same as GetCollectionInfo, but extracting will be
+// aborted when ctx is done
+func GetCollectionInfoContext(ctx context.Context, message string, opts ...Option) (*engine.CollectionInfo, error) {
+	e := newEngine(opts...)
+	collection, err := e.GetCollectionInfoContext(ctx, message)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	for i := range collection.Entries {
+		if collection.Entries[i].Err != nil {
+			collection.Entries[i].Err = convertErr(collection.Entries[i].Err)
+		}
+	}
+	return collection, nil
+}
+
+// GetBatch process every message same as Get, but concurrently. Accept same
+// options as Get, number of simultaneous extractions and downloads can be
+// changed with OptionExtractWorkers and OptionDownloadWorkers.
+// Results are returned in input order, each with its own error
+func GetBatch(messages []string, opts ...Option) []engine.BatchResult {
+	return GetBatchContext(context.Background(), messages, opts...)
+}
+
+// GetBatchContext same as GetBatch, but whole process will be aborted when
+// ctx is done. Unfinished messages get ErrCancelled
+func GetBatchContext(ctx context.Context, messages []string, opts ...Option) []engine.BatchResult {
+	results := make([]engine.BatchResult, len(messages))
+	for res := range GetBatchStream(ctx, messages, opts...) {
+		results[res.Index] = res
+	}
+	return results
+}
+
+// GetBatchStream same as GetBatchContext, but send results as soon as
+// they are ready, in order of completion. Channel is closed after the last
+// result. It must be read till the end, otherwise downloading stalls
+func GetBatchStream(ctx context.Context, messages []string, opts ...Option) <-chan engine.BatchResult {
+	e := newEngine(opts...)
+	results := make(chan engine.BatchResult)
+	go func() {
+		defer close(results)
+		for res := range e.StreamBatch(ctx, messages) {
+			if res.Err != nil {
+				res.Err = convertErr(res.Err)
+			}
+			results <- res
+		}
+	}()
+	return results
+}
+
+// GetAll process every url found in message, unlike Get, which use only the
+// first one. Accept same options as GetBatch.
+// Return ErrNotURL if there are no urls, otherwise results of every url
+// in order of appearance, each with its own error
+func GetAll(message string, opts ...Option) ([]engine.BatchResult, error) {
+	return GetAllContext(context.Background(), message, opts...)
+}
+
+// GetAllContext same as GetAll, but whole process will be aborted when ctx is done
+func GetAllContext(ctx context.Context, message string, opts ...Option) ([]engine.BatchResult, error) {
+	urls := engine.ExtractURLs(message)
+	if len(urls) < 1 {
+		return nil, convertErr(engine.ErrNotURL{})
+	}
+	messages := make([]string, len(urls))
+	for i, u := range urls {
+		messages[i] = u.String()
+	}
+	return GetBatchContext(ctx, messages, opts...), nil
+}
+
+func newEngine(opts ...Option) *engine.Engine {
 	options := options{
 		output:   "out",
 		truncate: false,
@@ -40,15 +171,32 @@ func GetInfo(message string, opts ...Option) (*engine.SongInfo, error) {
 	for _, o := range opts {
 		o.apply(&options)
 	}
-	e := engine.New(
+	return engine.New(
 		options.output,
 		options.truncate,
+		engine.Config{
+			Collection: parsers.CollectionOptions{
+				Limit:      options.limit,
+				After:      options.after,
+				NoPlaylist: options.noList,
+			},
+			Profile:         options.profile,
+			Progress:        options.progress,
+			ExtractWorkers:  options.extractWorkers,
+			DownloadWorkers: options.downloadWorkers,
+			Archive:         options.archive,
+			Template:        options.template,
+			Collision:       options.collision,
+			ASCIIFilenames:  options.ascii,
+			Cover:           options.cover,
+			Chapters:        options.chapters,
+			ChapterTemplate: options.chapterTemplate,
+			Lyrics:          options.lyrics,
+			Sidecars:        options.sidecars,
+			InfoProcessors:  options.processors,
+			MetadataRules:   options.rules,
+		},
 	)
-	song, err := e.GetInfo(message)
-	if err != nil {
-		return nil, convertErr(err)
-	}
-	return song, nil
 }
 
 func convertErr(err error) error {
@@ -66,6 +214,14 @@ func convertErr(err error) error {
 		convertedErr = ErrUnsupportedProtocol{err.(engine.ErrUnsupportedProtocol)}
 	case engine.ErrDownloadingError:
 		convertedErr = ErrDownloadingError{err.(engine.ErrDownloadingError)}
+	case engine.ErrCancelled:
+		convertedErr = ErrCancelled{err.(engine.ErrCancelled)}
+	case engine.ErrArchived:
+		convertedErr = ErrArchived{err.(engine.ErrArchived)}
+	case engine.ErrFileExists:
+		convertedErr = ErrFileExists{err.(engine.ErrFileExists)}
+	case engine.ErrInvalidRange:
+		convertedErr = ErrInvalidRange{err.(engine.ErrInvalidRange)}
 	case engine.ErrUndefined:
 		convertedErr = ErrUndefined{err.(engine.ErrUndefined)}
 	default:
@@ -1,8 +1,34 @@
 package erzo
 
+import (
+	"time"
+
+	"github.com/camelva/erzo/engine"
+	"github.com/camelva/erzo/loaders"
+)
+
 type options struct {
 	output   string
 	truncate bool
+	limit    int
+	after    time.Time
+	noList   bool
+	profile  loaders.Profile
+	progress func(engine.Progress)
+	// concurrency limits of batch processing
+	extractWorkers  int
+	downloadWorkers int
+	archive         engine.Archive
+	template        string
+	collision       engine.Collision
+	ascii           bool
+	processors      []engine.InfoProcessor
+	rules           []engine.MetadataRule
+	cover           engine.CoverOptions
+	sidecars        engine.SidecarOptions
+	lyrics          engine.LyricsOptions
+	chapters        engine.ChapterMode
+	chapterTemplate string
 	//metadata bool
 }
 
@@ -30,6 +56,267 @@ func OptionOutput(s string) Option {
 	return outputOption(s)
 }
 
+type limitOption int
+
+func (opt limitOption) apply(opts *options) {
+	opts.limit = int(opt)
+}
+
+// OptionLimit set maximum number of songs extracted from collection
+// (playlist, user profile, etc). 0 means unlimited
+func OptionLimit(n int) Option {
+	return limitOption(n)
+}
+
+type afterOption time.Time
+
+func (opt afterOption) apply(opts *options) {
+	opts.after = time.Time(opt)
+}
+
+// OptionAfter skip collection's songs uploaded before given time
+func OptionAfter(t time.Time) Option {
+	return afterOption(t)
+}
+
+type noPlaylistOption bool
+
+func (opt noPlaylistOption) apply(opts *options) {
+	opts.noList = bool(opt)
+}
+
+// OptionNoPlaylist make GetCollection to download only single song for urls
+// pointing both to song and playlist (like youtube's watch?v=...&list=...)
+// instead of the whole playlist. Get always download only the song of
+// such urls
+func OptionNoPlaylist(b bool) Option {
+	return noPlaylistOption(b)
+}
+
+type profileOption loaders.Profile
+
+func (opt profileOption) apply(opts *options) {
+	opts.profile = loaders.Profile(opt)
+}
+
+// OptionProfile set codec and quality of output files.
+// Use loaders.Profile{Codec: loaders.CodecCopy} to keep original stream
+// without re-encoding. Default is mp3 128k
+func OptionProfile(p loaders.Profile) Option {
+	return profileOption(p)
+}
+
+type progressOption func(engine.Progress)
+
+func (opt progressOption) apply(opts *options) {
+	opts.progress = opt
+}
+
+// OptionProgress register callback, which receive stage changes and
+// downloading progress of every song. Events of concurrent downloads can be
+// distinguished by Progress.URL and Progress.Song. Callback is called
+// synchronously from downloading goroutine, so it shouldn't block for long
+func OptionProgress(f func(engine.Progress)) Option {
+	return progressOption(f)
+}
+
+// OptionProgressChan same as OptionProgress, but send updates into channel.
+// Sending is blocking, so channel must be read (or buffered) during whole
+// process, otherwise downloading stalls
+func OptionProgressChan(ch chan<- engine.Progress) Option {
+	return progressOption(func(p engine.Progress) {
+		ch <- p
+	})
+}
+
+type extractWorkersOption int
+
+func (opt extractWorkersOption) apply(opts *options) {
+	opts.extractWorkers = int(opt)
+}
+
+// OptionExtractWorkers set number of urls extracted at once by GetBatch.
+// Default is 4
+func OptionExtractWorkers(n int) Option {
+	return extractWorkersOption(n)
+}
+
+type downloadWorkersOption int
+
+func (opt downloadWorkersOption) apply(opts *options) {
+	opts.downloadWorkers = int(opt)
+}
+
+// OptionDownloadWorkers set number of songs downloaded and processed by
+// ffmpeg at once by GetBatch. Default is 2
+func OptionDownloadWorkers(n int) Option {
+	return downloadWorkersOption(n)
+}
+
+type archiveOption struct {
+	engine.Archive
+}
+
+func (opt archiveOption) apply(opts *options) {
+	opts.archive = opt.Archive
+}
+
+// OptionArchive skip songs, which are already in archive, with
+// ErrArchived and add every downloaded song to it.
+// Use engine.NewFileArchive for youtube-dl compatible archive file
+func OptionArchive(a engine.Archive) Option {
+	return archiveOption{a}
+}
+
+type templateOption string
+
+func (opt templateOption) apply(opts *options) {
+	opts.template = string(opt)
+}
+
+// OptionTemplate set template of output file path, relative to output
+// folder, like "{artist}/{album}/{title} [{id}].{ext}".
+// Available fields: id, title, permalink, uploader, artist, album, genre, label,
+// extractor, date, year and ext. Default is "{permalink}.{ext}".
+// Names are made safe with engine.SanitizeFilename. It composes combining
+// marks only of common latin and cyrillic letters, it isn't full NFC. So
+// names in other scripts keep form they were extracted in, and
+// OptionCollision treats their composed and decomposed forms as different
+// files
+func OptionTemplate(s string) Option {
+	return templateOption(s)
+}
+
+type chaptersOption engine.ChapterMode
+
+func (opt chaptersOption) apply(opts *options) {
+	opts.chapters = engine.ChapterMode(opt)
+}
+
+// OptionChapters define what to do with songs, which have chapters, like
+// DJ mixes on YouTube: write them as markers or split song into separate
+// files (requires ffmpeg)
+func OptionChapters(mode engine.ChapterMode) Option {
+	return chaptersOption(mode)
+}
+
+type chapterTemplateOption string
+
+func (opt chapterTemplateOption) apply(opts *options) {
+	opts.chapterTemplate = string(opt)
+}
+
+// OptionChapterTemplate set template of chapter files, when song is split.
+// It has the same fields as OptionTemplate and {track}, while {album} is
+// song's title. Default is "{album}/{track} - {title}.{ext}"
+func OptionChapterTemplate(s string) Option {
+	return chapterTemplateOption(s)
+}
+
+type collisionOption engine.Collision
+
+func (opt collisionOption) apply(opts *options) {
+	opts.collision = engine.Collision(opt)
+}
+
+// OptionCollision set what to do if output file already exists:
+// overwrite it (default), skip song with ErrFileExists or add numbered suffix
+func OptionCollision(c engine.Collision) Option {
+	return collisionOption(c)
+}
+
+type asciiOption bool
+
+func (opt asciiOption) apply(opts *options) {
+	opts.ascii = bool(opt)
+}
+
+// OptionASCIIFilenames transliterate output file names into ascii, like
+// "Ёлка - Привет.mp3" into "Yolka - Privet.mp3". Characters without known
+// transliteration are removed
+func OptionASCIIFilenames(b bool) Option {
+	return asciiOption(b)
+}
+
+type metadataRuleOption engine.MetadataRule
+
+func (opt metadataRuleOption) apply(opts *options) {
+	opts.rules = append(opts.rules, engine.MetadataRule(opt))
+}
+
+// OptionMetadataRule add rule, which change metadata of every song right
+// after extraction, like engine.SplitArtistTitle("YouTube").
+// Option can be used multiple times, rules are applied in given order
+func OptionMetadataRule(rule engine.MetadataRule) Option {
+	return metadataRuleOption(rule)
+}
+
+// OptionOverrides replace title, artists, album, cover, track number or
+// downloaded part of song. It's applied to every song, so it's intended for single-song urls.
+// To change metadata of certain song use GetInfo and SongInfo.Override
+func OptionOverrides(o engine.Overrides) Option {
+	return metadataRuleOption(engine.OverrideRule(o))
+}
+
+// OptionTrim download only part of song between start and end, like a
+// single set of long stream. End 0 means the end of song. As OptionOverrides,
+// it's applied to every song
+func OptionTrim(start time.Duration, end time.Duration) Option {
+	return metadataRuleOption(engine.OverrideRule(engine.Overrides{Start: start, End: end}))
+}
+
+type infoProcessorOption engine.InfoProcessor
+
+func (opt infoProcessorOption) apply(opts *options) {
+	opts.processors = append(opts.processors, engine.InfoProcessor(opt))
+}
+
+// OptionInfoProcessor add processor, which change extracted info of every
+// song before metadata is created, like engine.ParseTitle("YouTube") for
+// splitting titles into artist, title and featured artists.
+// Option can be used multiple times, processors are applied in given order
+func OptionInfoProcessor(p engine.InfoProcessor) Option {
+	return infoProcessorOption(p)
+}
+
+type coverOption engine.CoverOptions
+
+func (opt coverOption) apply(opts *options) {
+	opts.cover = engine.CoverOptions(opt)
+}
+
+// OptionCover set preferred cover size and how it's processed before
+// embedding, like cropping to square or fitting into size limit
+func OptionCover(o engine.CoverOptions) Option {
+	return coverOption(o)
+}
+
+type sidecarsOption engine.SidecarOptions
+
+func (opt sidecarsOption) apply(opts *options) {
+	opts.sidecars = engine.SidecarOptions(opt)
+}
+
+// OptionSidecars write files next to downloaded song, like json dump of
+// extracted info or its cover. Cover is the embedded one, processed by
+// OptionCover, not the original artwork
+func OptionSidecars(o engine.SidecarOptions) Option {
+	return sidecarsOption(o)
+}
+
+type lyricsOption engine.LyricsOptions
+
+func (opt lyricsOption) apply(opts *options) {
+	opts.lyrics = engine.LyricsOptions(opt)
+}
+
+// OptionLyrics fetch lyrics from song's captions, like YouTube subtitles,
+// in preferred languages. They are embedded into output file and/or saved
+// as sidecars, see OptionSidecars
+func OptionLyrics(o engine.LyricsOptions) Option {
+	return lyricsOption(o)
+}
+
 //type metadataOption bool
 //
 //func (opt metadataOption) apply(opts *options) {
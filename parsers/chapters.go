@@ -0,0 +1,89 @@
+package parsers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chapter is a named part of song, like a track of DJ mix
+type Chapter struct {
+	Title string
+	Start time.Duration
+	// End of chapter. 0 means it lasts till the end of song
+	End time.Duration
+}
+
+var (
+	// _chapterStartRE match lines like "01:23 Title" or "[1:02:03] - Title"
+	_chapterStartRE = regexp.MustCompile(`^[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?(?:\s*[-–—:|]\s*|\s+)(.+)$`)
+	// _chapterEndRE match lines like "Title 01:23" or "1. Title - (1:02:03)"
+	_chapterEndRE = regexp.MustCompile(`^(.+?)(?:\s*[-–—:|]\s*|\s+)[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?$`)
+	// _chapterRangeEndRE match end of range after chapter's start, like
+	// "01:30 " of "00:00 - 01:30 Intro"
+	_chapterRangeEndRE = regexp.MustCompile(`^[\[(]?(?:\d{1,2}:)?\d{1,2}:\d{2}[\])]?(?:\s*[-–—:|]\s*|\s+)`)
+	// _chapterNumberRE match numbering before title, like "1. " or "02) "
+	_chapterNumberRE = regexp.MustCompile(`^\d{1,3}[.)]\s+`)
+)
+
+// ParseChapters find list of timestamps in description, like
+// "00:00 Intro\n03:15 Artist - Song". As YouTube does, list has to start
+// at 00:00, have at least 2 entries in ascending order and fit into
+// duration, if it's known. Return nil if there is no such list
+func ParseChapters(description string, duration time.Duration) []Chapter {
+	var chapters []Chapter
+	for _, line := range strings.Split(description, "\n") {
+		line = strings.TrimSpace(line)
+		var stamp, title string
+		if m := _chapterStartRE.FindStringSubmatch(line); m != nil {
+			// range like "00:00 - 01:30 Intro" is named after its start
+			stamp, title = m[1], _chapterRangeEndRE.ReplaceAllString(m[2], "")
+		} else if m := _chapterEndRE.FindStringSubmatch(line); m != nil {
+			stamp, title = m[2], m[1]
+		} else {
+			continue
+		}
+		start, ok := parseTimestamp(stamp)
+		if !ok {
+			continue
+		}
+		if len(chapters) < 1 && start > 0 {
+			// list should start at 00:00, this is some other timestamp
+			continue
+		}
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].Start {
+			if start == 0 {
+				// the next list starts, keep only the first one
+				break
+			}
+			continue
+		}
+		if duration > 0 && start >= duration {
+			break
+		}
+		chapters = append(chapters, Chapter{Title: _chapterNumberRE.ReplaceAllString(title, ""), Start: start})
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	for i := range chapters[:len(chapters)-1] {
+		chapters[i].End = chapters[i+1].Start
+	}
+	chapters[len(chapters)-1].End = duration
+	return chapters
+}
+
+// parseTimestamp parse "h:mm:ss" or "m:ss"
+func parseTimestamp(s string) (time.Duration, bool) {
+	var total time.Duration
+	parts := strings.Split(s, ":")
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || (i > 0 && n > 59) {
+			return 0, false
+		}
+		total = total*60 + time.Duration(n)
+	}
+	return total * time.Second, true
+}
@@ -0,0 +1,56 @@
+package parsers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseChapters(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		duration    time.Duration
+		want        []Chapter
+	}{
+		{
+			"start timestamps",
+			"Tracklist:\n00:00 Intro\n[01:30] - Artist - Song\n1:02:03 Outro",
+			2 * time.Hour,
+			[]Chapter{
+				{Title: "Intro", Start: 0, End: 90 * time.Second},
+				{Title: "Artist - Song", Start: 90 * time.Second, End: time.Hour + 2*time.Minute + 3*time.Second},
+				{Title: "Outro", Start: time.Hour + 2*time.Minute + 3*time.Second, End: 2 * time.Hour},
+			},
+		},
+		{
+			"end timestamps",
+			"1. Intro (00:00)\n2. Song - 2:30",
+			5 * time.Minute,
+			[]Chapter{
+				{Title: "Intro", Start: 0, End: 150 * time.Second},
+				{Title: "Song", Start: 150 * time.Second, End: 5 * time.Minute},
+			},
+		},
+		{
+			"ranges",
+			"00:00 - 01:30 Intro\n01:30-04:00 Artist - Song\n[04:00 – 05:00] Outro",
+			5 * time.Minute,
+			[]Chapter{
+				{Title: "Intro", Start: 0, End: 90 * time.Second},
+				{Title: "Artist - Song", Start: 90 * time.Second, End: 4 * time.Minute},
+				{Title: "Outro", Start: 4 * time.Minute, End: 5 * time.Minute},
+			},
+		},
+		{"not from the beginning", "01:00 Song\n02:00 Other", 0, nil},
+		{"single", "00:00 Song", 0, nil},
+		{"after the end", "00:00 Intro\n10:00 Song", 5 * time.Minute, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseChapters(tt.description, tt.duration); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseChapters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,24 +1,51 @@
 package parsers
 
 import (
+	"context"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Extractor interface {
 	Name() string
-	Extract(url.URL) (*ExtractorInfo, error)
+	Extract(context.Context, url.URL) (*ExtractorInfo, error)
 	Compatible(url.URL) bool
 }
 
+// CollectionExtractor is implemented by extractors, which can handle
+// urls with multiple songs inside (playlists, user profiles, etc)
+type CollectionExtractor interface {
+	Extractor
+	ExtractCollection(context.Context, url.URL, CollectionOptions) (*Collection, error)
+}
+
+// CollectionOptions limit which songs of collection should be extracted
+type CollectionOptions struct {
+	// Limit is a maximum number of songs. 0 means unlimited
+	Limit int
+	// After skip songs uploaded before this time. Zero value means no filtering
+	After time.Time
+	// NoPlaylist make extractor to return only single song for urls, pointing
+	// both to song and playlist (like youtube's watch?v=...&list=...).
+	// Extractor.Extract always return the song of such urls
+	NoPlaylist bool
+}
+
 type Format struct {
 	Url      string
 	Ext      string
 	Type     string
 	Protocol string
 	Score    int
+	// Codec of audio stream: mp3, opus, aac, flac or vorbis.
+	// Empty if unknown
+	Codec string
+	// Bitrate of audio stream in kbit/s. 0 if unknown
+	Bitrate int
 }
 
 type Formats []Format
@@ -28,14 +55,92 @@ func (formats *Formats) Add(t Transcodinger) {
 	ext := re.Split(t.GetPreset(), -1)[0]
 	re = regexp.MustCompile(`audio/([\w-]+)[;]?`)
 	mimeType := re.FindStringSubmatch(t.GetMimeType())[1]
+	codec := CodecFromMime(t.GetMimeType())
+	if len(codec) < 1 {
+		codec = CodecFromExt(ext)
+	}
 	f := Format{
 		Url:      t.GetURL(),
 		Type:     mimeType,
 		Protocol: t.GetProtocol(),
 		Ext:      ext,
+		Codec:    codec,
+		Bitrate:  presetBitrate(t.GetPreset(), codec),
 	}
 	*formats = append(*formats, f)
 }
+
+// presetBitrate guess bitrate from transcoding preset, like "aac_160k".
+// Presets without explicit bitrate use service defaults
+func presetBitrate(preset string, codec string) int {
+	re := regexp.MustCompile(`_(\d+)k`)
+	if m := re.FindStringSubmatch(preset); m != nil {
+		bitrate, _ := strconv.Atoi(m[1])
+		return bitrate
+	}
+	switch codec {
+	case "mp3":
+		return 128
+	case "opus":
+		return 64
+	default:
+		return 0
+	}
+}
+
+// CodecFromMime return audio codec described by mime type, like
+// `audio/webm; codecs="opus"`. Return empty string if codec unknown
+func CodecFromMime(mimeType string) string {
+	mimeType = strings.ToLower(mimeType)
+	re := regexp.MustCompile(`codecs="?([^"]+)"?`)
+	if m := re.FindStringSubmatch(mimeType); m != nil {
+		for _, c := range strings.Split(m[1], ",") {
+			c = strings.TrimSpace(c)
+			switch {
+			case c == "opus":
+				return "opus"
+			case c == "vorbis":
+				return "vorbis"
+			case c == "flac":
+				return "flac"
+			case c == "mp3", strings.HasPrefix(c, "mp4a.40.34"), strings.HasPrefix(c, "mp4a.6b"):
+				return "mp3"
+			case strings.HasPrefix(c, "mp4a"):
+				return "aac"
+			}
+		}
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "audio/mpeg"), strings.HasPrefix(mimeType, "audio/mp3"):
+		return "mp3"
+	case strings.HasPrefix(mimeType, "audio/flac"):
+		return "flac"
+	case strings.HasPrefix(mimeType, "audio/aac"):
+		return "aac"
+	default:
+		return ""
+	}
+}
+
+// CodecFromExt guess audio codec by file extension.
+// Return empty string if codec unknown
+func CodecFromExt(ext string) string {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "mp3":
+		return "mp3"
+	case "opus":
+		return "opus"
+	case "aac", "m4a":
+		return "aac"
+	case "flac":
+		return "flac"
+	case "ogg", "oga":
+		return "vorbis"
+	default:
+		return ""
+	}
+}
 func (formats *Formats) Sort() {
 	formatsCopy := make(Formats, len(*formats))
 	copy(formatsCopy, *formats)
@@ -64,26 +169,60 @@ func (formats *Formats) Sort() {
 }
 
 type ExtractorInfo struct {
-	//ID        int
+	// ID is a stable identifier of song inside its service
+	ID string
+	// Extractor is a name of extractor, which produced this info.
+	// It's filled by engine
+	Extractor string
 	Permalink string
 	Uploader  string
 	//UploaderID   int
 	//UploaderURL  string
 	Timestamp time.Time
 	Title     string
-	//Description string
-	Thumbnails map[string]Artwork
-	Duration   time.Duration
-	//WebPageURL  string
+	// Artist is a performer, when it's known and differ from Uploader
+	Artist string
+	// Featured are guest artists of song
+	Featured    []string
+	Description string
+	Genre       string
+	Tags        []string
+	// Label is a record label or publisher
+	Label string
+	ISRC  string
+	// ReleaseDate is an original release date, when it's known
+	ReleaseDate time.Time
+	Thumbnails  map[string]Artwork
+	Duration    time.Duration
+	// WebPageURL is a link to song's page
+	WebPageURL string
+	// Captions are available subtitle tracks, used as lyrics
+	Captions []Caption
+	// Chapters of long songs, like DJ mixes
+	Chapters []Chapter
 	//License      string
 	//ViewCount    int
 	//LikeCount    int
 	//CommentCount int
 	//RepostCount  int
-	//Genre   string
 	Formats Formats
 }
 
+// Collection contain info about multi-track source, like playlist
+type Collection struct {
+	Title     string
+	Permalink string
+	Uploader  string
+	Entries   []CollectionEntry
+}
+
+// CollectionEntry is a single song of Collection. If this song can't be
+// extracted - Info is nil and Err contain reason
+type CollectionEntry struct {
+	Info *ExtractorInfo
+	Err  error
+}
+
 type Transcodinger interface {
 	GetURL() string
 	GetPreset() string
@@ -91,6 +230,17 @@ type Transcodinger interface {
 	GetMimeType() string
 }
 
+// Caption is a subtitle track of song
+type Caption struct {
+	// Language code, like "en" or "pt-BR"
+	Language string
+	Name     string
+	// URL of track in timed-text xml format
+	URL string
+	// AutoGenerated is true for speech recognized tracks
+	AutoGenerated bool
+}
+
 type Artwork struct {
 	Type string
 	URL  string
@@ -1,15 +1,20 @@
 package soundcloud
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/camelva/erzo/engine"
@@ -31,23 +36,33 @@ const (
 
 func (k urlKind) String() string {
 	switch k {
-	case 0:
+	case _song:
 		return "song"
-	case 1:
+	case _playlist:
 		return "playlist"
-	case 2:
+	case _station:
 		return "station"
-	case 3:
+	case _user:
 		return "user"
 	default:
 		return "undefined"
 	}
 }
 
+// _pageSize is a number of items requested per page from paginated endpoints
+const _pageSize = 50
+
+// _stubsBatch is a number of tracks fetched at once by their ids. Api
+// doesn't allow to fetch more than 50 tracks per request
+const _stubsBatch = 50
+
 var tokenFile = path.Join(os.TempDir(), "soundcloud-token.txt")
 
 var IE extractor
 
+// tokenMu guard IE.clientID, which is updated when token become outdated
+var tokenMu sync.RWMutex
+
 func init() {
 	//noinspection SpellCheckingInspection
 	clientIDBase := "psT32GLDMZ0TQKgfPkzrGIlco3PYA1kf"
@@ -94,22 +109,109 @@ func (ie extractor) Compatible(u url.URL) bool {
 	return ok
 }
 
-func (ie extractor) Extract(u url.URL) (*parsers.ExtractorInfo, error) {
-	sc := parseURL(u)
+func (ie extractor) Extract(ctx context.Context, u url.URL) (*parsers.ExtractorInfo, error) {
+	sc := parseURL(ctx, u)
+	if sc == nil {
+		return nil, parsers.ErrCantContinue{Reason: "can't parse url"}
+	}
 	if sc.kind != _song {
 		return nil, parsers.ErrFormatNotSupported{Format: sc.kind.String()}
 	}
-	metadata, err := resolve(sc.url)
+	metadata, err := resolve(ctx, sc.url)
 	if err != nil || (metadata == &metadata2{}) {
 		return nil, parsers.ErrCantContinue{Reason: "can't get song metadata"}
 	}
-	info, err := extractInfo(metadata)
+	info, err := extractInfo(ctx, metadata)
 	if err != nil {
 		return nil, parsers.ErrCantContinue{Reason: err.Error()}
 	}
 	return info, nil
 }
 
+// ExtractCollection extract info about every song from multi-track url
+func (ie extractor) ExtractCollection(ctx context.Context, u url.URL, opts parsers.CollectionOptions) (*parsers.Collection, error) {
+	sc := parseURL(ctx, u)
+	if sc == nil {
+		return nil, parsers.ErrCantContinue{Reason: "can't parse url"}
+	}
+	switch sc.kind {
+	case _playlist:
+		pl, err := resolvePlaylist(ctx, sc.url)
+		if err != nil {
+			return nil, err
+		}
+		collection := &parsers.Collection{
+			Title:     pl.Title,
+			Permalink: pl.Permalink,
+			Uploader:  pl.User.Username,
+		}
+		tracks := selectTracks(ctx, pl.Tracks, opts)
+		collection.Entries = extractEntries(ctx, tracks)
+		return collection, nil
+	case _station:
+		st, err := resolvePlaylist(ctx, sc.url)
+		if err != nil {
+			return nil, err
+		}
+		tracks := selectTracks(ctx, st.Tracks, opts)
+		if len(st.Tracks) < 1 && len(st.Urn) > 0 {
+			// station's tracks aren't included into resolved object, so fetch
+			// them separately. Api list them by station's urn, like
+			// GET /stations/soundcloud:track-stations:123/tracks, with the
+			// same pagination as user's tracks:
+			// {"collection": [tracks...], "next_href": "next page url"}
+			uri := fmt.Sprintf("%sstations/%s/tracks?limit=%d&linked_partitioning=1",
+				IE.api2URL, st.Urn, _pageSize)
+			pageOpts := opts
+			if !opts.After.IsZero() {
+				// unlike user's tracks, station isn't sorted by date, so
+				// every page is fetched and tracks are filtered one by one
+				pageOpts = parsers.CollectionOptions{}
+			}
+			pages, err := fetchPages(ctx, uri, pageOpts)
+			if err != nil {
+				return nil, err
+			}
+			list := make([]metadata2, 0, len(pages))
+			for _, track := range pages {
+				list = append(list, *track)
+			}
+			tracks = selectTracks(ctx, list, opts)
+		}
+		uploader := st.User.Username
+		if len(uploader) < 1 {
+			uploader = sc.user
+		}
+		collection := &parsers.Collection{
+			Title:     st.Title,
+			Permalink: st.Permalink,
+			Uploader:  uploader,
+		}
+		collection.Entries = extractEntries(ctx, tracks)
+		return collection, nil
+	case _user:
+		usr, err := resolveUser(ctx, sc.url)
+		if err != nil {
+			return nil, err
+		}
+		uri := fmt.Sprintf("%susers/%d/tracks?limit=%d&linked_partitioning=1",
+			IE.api2URL, usr.ID, _pageSize)
+		tracks, err := fetchPages(ctx, uri, opts)
+		if err != nil {
+			return nil, err
+		}
+		collection := &parsers.Collection{
+			Title:     usr.Username,
+			Permalink: usr.Permalink,
+			Uploader:  usr.Username,
+		}
+		collection.Entries = extractEntries(ctx, tracks)
+		return collection, nil
+	default:
+		return nil, parsers.ErrFormatNotSupported{Format: sc.kind.String()}
+	}
+}
+
 // Struct containing info about user-provided url
 type scURL struct {
 	title  string
@@ -119,9 +221,9 @@ type scURL struct {
 	url    string
 }
 
-func parseURL(u url.URL) *scURL {
+func parseURL(ctx context.Context, u url.URL) *scURL {
 	if u.Host == "soundcloud.app.goo.gl" {
-		newURL, err := getRealURL(&u)
+		newURL, err := getRealURL(ctx, &u)
 		if err != nil {
 			return nil
 		}
@@ -163,7 +265,7 @@ func parseURL(u url.URL) *scURL {
 		case _station:
 			uri = fmt.Sprintf("%sstations/track/%s/%s", IE.baseURL, user, title)
 		case _playlist:
-			uri = fmt.Sprintf("%ssets/%s/%s", IE.baseURL, user, title)
+			uri = fmt.Sprintf("%s%s/sets/%s", IE.baseURL, user, title)
 		case _user:
 			uri = fmt.Sprintf("%s%s", IE.baseURL, user)
 		case _song:
@@ -189,31 +291,199 @@ func parseURL(u url.URL) *scURL {
 	return &scURL{}
 }
 
-func resolve(link string) (*metadata2, error) {
+func resolve(ctx context.Context, link string) (*metadata2, error) {
+	var scMetadata = new(metadata2)
+	if err := resolveInto(ctx, link, scMetadata); err != nil {
+		return nil, err
+	}
+	return scMetadata, nil
+}
+
+func resolvePlaylist(ctx context.Context, link string) (*playlist, error) {
+	var pl = new(playlist)
+	if err := resolveInto(ctx, link, pl); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+func resolveUser(ctx context.Context, link string) (*user2, error) {
+	var usr = new(user2)
+	if err := resolveInto(ctx, link, usr); err != nil {
+		return nil, err
+	}
+	return usr, nil
+}
+
+// resolveInto fetch api's resolve endpoint for given link and unmarshal
+// result into v
+func resolveInto(ctx context.Context, link string, v interface{}) error {
 	uri := fmt.Sprintf("%sresolve?url=%s", IE.api2URL, link)
 	resolveURL, err := url.Parse(uri)
 	if err != nil {
-		return nil, parsers.ErrCantContinue{Reason: fmt.Sprintf("can't parse url: %s", uri)}
+		return parsers.ErrCantContinue{Reason: fmt.Sprintf("can't parse url: %s", uri)}
 	}
-	res, err := fetch(resolveURL)
+	res, err := fetch(ctx, resolveURL)
 	// empty json object "{}"
 	if err != nil || (len(res) < 3) {
-		return nil, parsers.ErrCantContinue{Reason: "can't fetch resolve url"}
+		return parsers.ErrCantContinue{Reason: "can't fetch resolve url"}
 	}
-	var scMetadata = new(metadata2)
-	if err := json.Unmarshal(res, &scMetadata); err != nil {
-		return nil, parsers.ErrCantContinue{Reason: "can't unmarshal fetched metadata"}
+	if err := json.Unmarshal(res, v); err != nil {
+		return parsers.ErrCantContinue{Reason: "can't unmarshal fetched metadata"}
 	}
-	scMetadata.DownloadURL = fmt.Sprintf("%stracks/%d/download", IE.api2URL, scMetadata.ID)
-	return scMetadata, nil
+	return nil
+}
+
+// extractEntries extract info about every track. Tracks which can't be
+// extracted are reported inside their entries
+func extractEntries(ctx context.Context, tracks []*metadata2) []parsers.CollectionEntry {
+	entries := make([]parsers.CollectionEntry, 0, len(tracks))
+	for _, track := range tracks {
+		if track.isStub() {
+			entries = append(entries, parsers.CollectionEntry{
+				Err: parsers.ErrCantContinue{Reason: fmt.Sprintf("track %d unavailable", track.ID)},
+			})
+			continue
+		}
+		info, err := extractInfo(ctx, track)
+		if err != nil {
+			entries = append(entries, parsers.CollectionEntry{
+				Err: parsers.ErrCantContinue{Reason: err.Error()},
+			})
+			continue
+		}
+		entries = append(entries, parsers.CollectionEntry{Info: info})
+	}
+	return entries
 }
 
-func extractInfo(info *metadata2) (*parsers.ExtractorInfo, error) {
-	formats, ok := info.getDownloadLink()
+// selectTracks drop tracks uploaded before opts.After and cut result to
+// opts.Limit. Stubs are kept as is, when they can't be filled, because we
+// don't know their upload date. Tracks are filled by batches, so stubs
+// after the limit is reached are never fetched
+func selectTracks(ctx context.Context, tracks []metadata2, opts parsers.CollectionOptions) []*metadata2 {
+	result := make([]*metadata2, 0)
+	for start := 0; start < len(tracks); start += _stubsBatch {
+		end := start + _stubsBatch
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		if opts.Limit > 0 && opts.After.IsZero() && end-start > opts.Limit-len(result) {
+			// nothing is dropped, so only this number of tracks is needed
+			end = start + opts.Limit - len(result)
+		}
+		for _, track := range fillStubs(ctx, tracks[start:end]) {
+			if opts.Limit > 0 && len(result) >= opts.Limit {
+				return result
+			}
+			if !opts.After.IsZero() && !track.isStub() && track.CreatedAt.Before(opts.After) {
+				continue
+			}
+			result = append(result, track)
+		}
+	}
+	return result
+}
+
+// fillStubs replace track stubs with full track's info. SoundCloud return full
+// info only for first few tracks of playlist, others contain only their ids.
+// Tracks which can't be fetched are left as stubs
+func fillStubs(ctx context.Context, tracks []metadata2) []*metadata2 {
+	result := make([]*metadata2, len(tracks))
+	stubs := make([]int, 0)
+	for i := range tracks {
+		result[i] = &tracks[i]
+		if tracks[i].isStub() {
+			stubs = append(stubs, tracks[i].ID)
+		}
+	}
+	fetched := make(map[int]*metadata2, len(stubs))
+	for start := 0; start < len(stubs); start += _stubsBatch {
+		end := start + _stubsBatch
+		if end > len(stubs) {
+			end = len(stubs)
+		}
+		batch, err := fetchTracks(ctx, stubs[start:end])
+		if err != nil {
+			continue
+		}
+		for j := range batch {
+			fetched[batch[j].ID] = &batch[j]
+		}
+	}
+	for i := range result {
+		if track, ok := fetched[result[i].ID]; ok {
+			result[i] = track
+		}
+	}
+	return result
+}
+
+// fetchPages page through paginated tracks list, starting from uri. Tracks
+// expected to be sorted from newest to oldest, so paging stops as soon as
+// opts.Limit reached or tracks become older than opts.After
+func fetchPages(ctx context.Context, uri string, opts parsers.CollectionOptions) ([]*metadata2, error) {
+	tracks := make([]*metadata2, 0)
+	for len(uri) > 0 {
+		pageURL, err := url.Parse(uri)
+		if err != nil {
+			return nil, parsers.ErrCantContinue{Reason: fmt.Sprintf("can't parse url: %s", uri)}
+		}
+		res, err := fetch(ctx, pageURL)
+		if err != nil {
+			return nil, parsers.ErrCantContinue{Reason: "can't fetch tracks list"}
+		}
+		var page tracksPage
+		if err := json.Unmarshal(res, &page); err != nil {
+			return nil, parsers.ErrCantContinue{Reason: "can't unmarshal tracks list"}
+		}
+		if len(page.Collection) < 1 {
+			break
+		}
+		for i := range page.Collection {
+			track := &page.Collection[i]
+			if !opts.After.IsZero() && track.CreatedAt.Before(opts.After) {
+				// tracks are sorted from newest, so rest of them are older too
+				return tracks, nil
+			}
+			tracks = append(tracks, track)
+			if opts.Limit > 0 && len(tracks) >= opts.Limit {
+				return tracks, nil
+			}
+		}
+		uri = page.NextHref
+	}
+	return tracks, nil
+}
+
+func fetchTracks(ctx context.Context, ids []int) ([]metadata2, error) {
+	strIDs := make([]string, len(ids))
+	for i, id := range ids {
+		strIDs[i] = strconv.Itoa(id)
+	}
+	uri := fmt.Sprintf("%stracks?ids=%s", IE.api2URL, strings.Join(strIDs, ","))
+	tracksURL, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	res, err := fetch(ctx, tracksURL)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []metadata2
+	if err := json.Unmarshal(res, &tracks); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+func extractInfo(ctx context.Context, info *metadata2) (*parsers.ExtractorInfo, error) {
+	info.DownloadURL = fmt.Sprintf("%stracks/%d/download", IE.api2URL, info.ID)
+	formats, ok := info.getDownloadLink(ctx)
 	if !ok {
 		var err error
 		transcodings := info.Media.Transcodings
-		formats, err = transcodings.extractFormats()
+		formats, err = transcodings.extractFormats(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("can't extract formats from transcodings")
 		}
@@ -226,30 +496,64 @@ func extractInfo(info *metadata2) (*parsers.ExtractorInfo, error) {
 	thumbnails := extractArtworks(info.ArtworkURL, info.User.AvatarURL)
 
 	var ExtractedInfo = &parsers.ExtractorInfo{
-		//ID:        info.ID,
+		ID:        strconv.Itoa(info.ID),
 		Permalink: info.Permalink,
 		Uploader:  info.User.Username,
 		//UploaderID:   info.User.ID,
 		//UploaderURL:  info.User.PermalinkURL,
-		Timestamp: time.Date(info.CreatedAt.Year(), info.CreatedAt.Month(), info.CreatedAt.Day(), 0, 0, 0, 0, time.UTC),
-		Title:     info.Title,
-		//Description: info.Description,
-		Thumbnails: thumbnails,
-		Duration:   duration,
-		//WebPageURL:  info.PermalinkURL,
+		Timestamp:   time.Date(info.CreatedAt.Year(), info.CreatedAt.Month(), info.CreatedAt.Day(), 0, 0, 0, 0, time.UTC),
+		Title:       info.Title,
+		Artist:      info.PublisherMetadata.Artist,
+		Description: info.Description,
+		Genre:       info.Genre,
+		Tags:        parseTagList(info.TagList),
+		Label:       info.LabelName,
+		ISRC:        info.PublisherMetadata.Isrc,
+		ReleaseDate: info.ReleaseDate,
+		Thumbnails:  thumbnails,
+		Duration:    duration,
+		WebPageURL:  info.PermalinkURL,
 		//License:      info.License,
 		//ViewCount:    info.PlaybackCount,
 		//LikeCount:    info.LikesCount,
 		//CommentCount: info.CommentCount,
 		//RepostCount:  info.RepostsCount,
-		//Genre:   info.Genre,
 		Formats: formats,
 	}
 
 	return ExtractedInfo, nil
 }
 
-func (info *metadata2) getDownloadLink() (formats parsers.Formats, ok bool) {
+// parseTagList split soundcloud's tag list, like `rock "hip hop" chill`.
+// Tags with spaces are quoted
+func parseTagList(list string) []string {
+	var tags []string
+	for len(list) > 0 {
+		list = strings.TrimLeft(list, " ")
+		var tag string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			if end < 0 {
+				tag, list = list[1:], ""
+			} else {
+				tag, list = list[1:end+1], list[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(list, ' ')
+			if end < 0 {
+				tag, list = list, ""
+			} else {
+				tag, list = list[:end], list[end:]
+			}
+		}
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (info *metadata2) getDownloadLink(ctx context.Context) (formats parsers.Formats, ok bool) {
 	if !info.Downloadable || !info.HasDownloadsLeft {
 		return nil, false
 	}
@@ -258,7 +562,7 @@ func (info *metadata2) getDownloadLink() (formats parsers.Formats, ok bool) {
 		// invalid url, just return false
 		return nil, false
 	}
-	res, err := fetch(dlURL)
+	res, err := fetch(ctx, dlURL)
 	if err != nil {
 		return nil, false
 	}
@@ -270,17 +574,36 @@ func (info *metadata2) getDownloadLink() (formats parsers.Formats, ok bool) {
 		return nil, false
 	}
 
+	ext := originalExt(realDlURL.URL)
 	format := parsers.Format{
 		Url:      realDlURL.URL,
-		Ext:      "mp3",
-		Type:     "mpeg",
+		Ext:      ext,
+		Type:     ext,
 		Protocol: "http",
 		Score:    100,
+		Codec:    parsers.CodecFromExt(ext),
 	}
 	return []parsers.Format{format}, true
 }
 
-func (transcodings transcodings) extractFormats() (parsers.Formats, error) {
+// originalExt return extension of original uploaded file. Its name is stored
+// either in url's path or in content-disposition query parameter
+func originalExt(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	name := u.Path
+	disposition := u.Query().Get("response-content-disposition")
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		if filename, ok := params["filename"]; ok {
+			name = filename
+		}
+	}
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+func (transcodings transcodings) extractFormats(ctx context.Context) (parsers.Formats, error) {
 	formats := make(parsers.Formats, 0)
 	for _, t := range transcodings {
 		formatURL, err := url.Parse(t.URL)
@@ -288,7 +611,7 @@ func (transcodings transcodings) extractFormats() (parsers.Formats, error) {
 			// invalid url, continue cycle
 			continue
 		}
-		stream, err := fetch(formatURL)
+		stream, err := fetch(ctx, formatURL)
 		if err != nil {
 			// can't fetch url, continue cycle
 			continue
@@ -352,20 +675,24 @@ func extractArtworks(artwork string, avatar string) map[string]parsers.Artwork {
 	return artworks
 }
 
-func fetch(u *url.URL) ([]byte, error) {
+func fetch(ctx context.Context, u *url.URL) ([]byte, error) {
 	// loop for two tries
 	for i := range []int{0, 0} {
 		q := u.Query()
-		q.Set("client_id", IE.clientID)
+		q.Set("client_id", clientID())
 		u.RawQuery = q.Encode()
-		res, err := utils.Fetch(u)
+		res, err := utils.FetchContext(ctx, u)
 		if err != nil {
+			if ctx.Err() != nil {
+				// no need for another try
+				return nil, ctx.Err()
+			}
 			// another try
 			continue
 		}
 		if len(res) < 1 && i == 0 {
 			// if its first fault, try update token
-			if err := updateToken(); err != nil {
+			if err := updateToken(ctx); err != nil {
 				continue
 			}
 			continue
@@ -375,10 +702,17 @@ func fetch(u *url.URL) ([]byte, error) {
 	return nil, fmt.Errorf("can't fetch url")
 }
 
-func updateToken() error {
+// clientID return current api token
+func clientID() string {
+	tokenMu.RLock()
+	defer tokenMu.RUnlock()
+	return IE.clientID
+}
+
+func updateToken(ctx context.Context) error {
 	// we are sure in this url, so can skip error
 	u, _ := url.Parse("https://soundcloud.com")
-	res, err := utils.Fetch(u)
+	res, err := utils.FetchContext(ctx, u)
 	if err != nil {
 		return err
 	}
@@ -394,7 +728,7 @@ func updateToken() error {
 			// can't parse script url. It's not fatal for us, so just ignore this
 			continue
 		}
-		scriptBody, err := utils.Fetch(scriptURL)
+		scriptBody, err := utils.FetchContext(ctx, scriptURL)
 		if err != nil {
 			// can't fetch script. It's not fatal for us, so just ignore this
 			continue
@@ -403,7 +737,9 @@ func updateToken() error {
 		if matches == nil {
 			continue
 		}
+		tokenMu.Lock()
 		IE.clientID = string(matches[1])
+		tokenMu.Unlock()
 		// just ignore error
 		_ = ioutil.WriteFile(tokenFile, matches[1], 0644)
 		return nil
@@ -411,10 +747,15 @@ func updateToken() error {
 	return fmt.Errorf("can't retrieve token")
 }
 
-func getRealURL(u *url.URL) (*url.URL, error) {
-	res, err := http.Get(u.String())
+func getRealURL(ctx context.Context, u *url.URL) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	_ = res.Body.Close()
 	return res.Request.URL, nil
 }
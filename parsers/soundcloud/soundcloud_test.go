@@ -0,0 +1,301 @@
+package soundcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+// fakeAPI serve fixture tracks by their ids and paginated lists of tracks.
+// It remember requested batches of ids and number of fetched pages
+type fakeAPI struct {
+	mu      sync.Mutex
+	batches [][]int
+	pages   int
+}
+
+// _userTracks is a number of tracks uploaded by fixture user 7. They are
+// listed from newest to oldest
+const _userTracks = 120
+
+const (
+	// _stationURL is a fixture station of track
+	_stationURL = "https://soundcloud.com/stations/track/artist/song"
+	_stationUrn = "soundcloud:track-stations:42"
+	// _stationTracks is a number of tracks in fixture station. They aren't
+	// sorted by upload date
+	_stationTracks = 60
+)
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/tracks":
+		var ids []int
+		tracks := make([]metadata2, 0)
+		for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			id, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+			tracks = append(tracks, withMedia(r, fixtureTrack(id)))
+		}
+		a.mu.Lock()
+		a.batches = append(a.batches, ids)
+		a.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(tracks)
+	case "/users/7/tracks":
+		ids := make([]int, _userTracks)
+		for i := range ids {
+			ids[i] = _userTracks - 1 - i
+		}
+		a.servePage(w, r, ids)
+	case "/resolve":
+		if r.URL.Query().Get("url") != _stationURL {
+			http.NotFound(w, r)
+			return
+		}
+		// like real api, station is resolved without its tracks
+		_ = json.NewEncoder(w).Encode(playlist{Title: "Station", Permalink: "artist-song", Urn: _stationUrn})
+	case "/stations/" + _stationUrn + "/tracks":
+		ids := make([]int, _stationTracks)
+		for i := range ids {
+			ids[i] = i * 7 % _stationTracks
+		}
+		a.servePage(w, r, ids)
+	case "/media":
+		_ = json.NewEncoder(w).Encode(map[string]string{"url": "https://cdn.test/" + r.URL.Query().Get("id") + ".mp3"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// servePage serve part of tracks list, selected by offset and limit
+// parameters, with link to the next page
+func (a *fakeAPI) servePage(w http.ResponseWriter, r *http.Request, ids []int) {
+	a.mu.Lock()
+	a.pages++
+	a.mu.Unlock()
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || offset > len(ids) {
+		http.Error(w, "invalid page", http.StatusBadRequest)
+		return
+	}
+	end := offset + limit
+	if end > len(ids) {
+		end = len(ids)
+	}
+	page := tracksPage{Collection: make([]metadata2, 0, end-offset)}
+	for _, id := range ids[offset:end] {
+		page.Collection = append(page.Collection, withMedia(r, fixtureTrack(id)))
+	}
+	if end < len(ids) {
+		page.NextHref = fmt.Sprintf("http://%s%s?offset=%d&limit=%d&linked_partitioning=1",
+			r.Host, r.URL.Path, end, limit)
+	}
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// pageCount return number of fetched pages
+func (a *fakeAPI) pageCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pages
+}
+
+// batchSizes return number of ids in every requested batch
+func (a *fakeAPI) batchSizes() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sizes := make([]int, 0, len(a.batches))
+	for _, b := range a.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+// useAPI point extractor to fake api. Returned function restore real one
+func useAPI(api http.Handler) func() {
+	srv := httptest.NewServer(api)
+	old := IE.api2URL
+	IE.api2URL = srv.URL + "/"
+	return func() {
+		IE.api2URL = old
+		srv.Close()
+	}
+}
+
+// fixtureTrack return full info of track. Track N is uploaded N days after
+// 2020-01-01
+func fixtureTrack(id int) metadata2 {
+	return metadata2{
+		ID:        id,
+		Permalink: "track-" + strconv.Itoa(id),
+		Title:     "Track " + strconv.Itoa(id),
+		CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, id),
+	}
+}
+
+// withMedia add progressive mp3 transcoding to track. Its url is resolved
+// by the same api, which served r
+func withMedia(r *http.Request, track metadata2) metadata2 {
+	t := transcoding{URL: fmt.Sprintf("http://%s/media?id=%d", r.Host, track.ID), Preset: "mp3_0_0"}
+	t.Format.Protocol, t.Format.MimeType = "progressive", "audio/mpeg"
+	track.Media.Transcodings = transcodings{t}
+	return track
+}
+
+func TestSelectTracks(t *testing.T) {
+	api := &fakeAPI{}
+	defer useAPI(api)()
+
+	// playlist of 120 tracks, where only the first 5 have full info
+	tracks := make([]metadata2, 120)
+	for i := range tracks {
+		if i < 5 {
+			tracks[i] = fixtureTrack(i)
+		} else {
+			tracks[i] = metadata2{ID: i}
+		}
+	}
+	tests := []struct {
+		name    string
+		opts    parsers.CollectionOptions
+		first   int
+		count   int
+		batches []int
+	}{
+		{"all", parsers.CollectionOptions{}, 0, 120, []int{45, 50, 20}},
+		{"limit", parsers.CollectionOptions{Limit: 30}, 0, 30, []int{25}},
+		{"limit of full tracks", parsers.CollectionOptions{Limit: 3}, 0, 3, []int{}},
+		{"after", parsers.CollectionOptions{After: fixtureTrack(100).CreatedAt}, 100, 20, []int{45, 50, 20}},
+		{"after with limit", parsers.CollectionOptions{After: fixtureTrack(30).CreatedAt, Limit: 10}, 30, 10, []int{45}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.batches = nil
+			got := selectTracks(context.Background(), tracks, tt.opts)
+			if len(got) != tt.count {
+				t.Fatalf("selectTracks() returned %d tracks, want %d", len(got), tt.count)
+			}
+			for i, track := range got {
+				if track.isStub() || track.ID != tt.first+i {
+					t.Errorf("track %d = %+v, want filled track %d", i, track, tt.first+i)
+					break
+				}
+			}
+			if sizes := api.batchSizes(); !reflect.DeepEqual(sizes, tt.batches) {
+				t.Errorf("requested batches of %v tracks, want %v", sizes, tt.batches)
+			}
+		})
+	}
+	if !tracks[50].isStub() {
+		t.Errorf("selectTracks() changed original tracks")
+	}
+}
+
+func TestFetchPages(t *testing.T) {
+	api := &fakeAPI{}
+	defer useAPI(api)()
+
+	tests := []struct {
+		name  string
+		opts  parsers.CollectionOptions
+		count int
+		pages int
+	}{
+		{"all", parsers.CollectionOptions{}, 120, 3},
+		{"limit inside the second page", parsers.CollectionOptions{Limit: 60}, 60, 2},
+		{"limit at the end of page", parsers.CollectionOptions{Limit: 50}, 50, 1},
+		{"after", parsers.CollectionOptions{After: fixtureTrack(80).CreatedAt}, 40, 1},
+		{"after inside the second page", parsers.CollectionOptions{After: fixtureTrack(50).CreatedAt}, 70, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.pages = 0
+			uri := fmt.Sprintf("%susers/7/tracks?limit=%d&linked_partitioning=1", IE.api2URL, _pageSize)
+			got, err := fetchPages(context.Background(), uri, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.count {
+				t.Fatalf("fetchPages() returned %d tracks, want %d", len(got), tt.count)
+			}
+			for i, track := range got {
+				// tracks are listed from the newest one
+				if want := _userTracks - 1 - i; track.ID != want {
+					t.Errorf("track %d has id %d, want %d", i, track.ID, want)
+					break
+				}
+			}
+			if pages := api.pageCount(); pages != tt.pages {
+				t.Errorf("fetched %d pages, want %d", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestExtractCollection_Station(t *testing.T) {
+	api := &fakeAPI{}
+	defer useAPI(api)()
+
+	u, _ := url.Parse(_stationURL)
+	after := fixtureTrack(30).CreatedAt
+	tests := []struct {
+		name  string
+		opts  parsers.CollectionOptions
+		count int
+		pages int
+	}{
+		{"all", parsers.CollectionOptions{}, _stationTracks, 2},
+		{"limit", parsers.CollectionOptions{Limit: 5}, 5, 1},
+		// the first track of station is older than others
+		{"after", parsers.CollectionOptions{After: after}, 30, 2},
+		{"after with limit", parsers.CollectionOptions{After: after, Limit: 5}, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.pages = 0
+			collection, err := IE.ExtractCollection(context.Background(), *u, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if collection.Title != "Station" || collection.Uploader != "artist" {
+				t.Errorf("collection = %q by %q", collection.Title, collection.Uploader)
+			}
+			if len(collection.Entries) != tt.count {
+				t.Fatalf("collection has %d entries, want %d", len(collection.Entries), tt.count)
+			}
+			var ids []int
+			for i := 0; i < _stationTracks; i++ {
+				if id := i * 7 % _stationTracks; !fixtureTrack(id).CreatedAt.Before(tt.opts.After) {
+					ids = append(ids, id)
+				}
+			}
+			for i, entry := range collection.Entries {
+				id := ids[i]
+				if entry.Err != nil || entry.Info.ID != strconv.Itoa(id) ||
+					entry.Info.Formats[0].Url != fmt.Sprintf("https://cdn.test/%d.mp3", id) {
+					t.Errorf("entry %d = %+v, %v", i, entry.Info, entry.Err)
+					break
+				}
+			}
+			if pages := api.pageCount(); pages != tt.pages {
+				t.Errorf("fetched %d pages, want %d", pages, tt.pages)
+			}
+		})
+	}
+}
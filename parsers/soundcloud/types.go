@@ -106,6 +106,38 @@ type metadata2 struct {
 	PlaybackCount     int       `json:"playback_count"`
 }
 
+// isStub report whether track contain only id, without any other info
+func (info metadata2) isStub() bool {
+	return len(info.Permalink) < 1
+}
+
+type playlist struct {
+	ArtworkURL   string      `json:"artwork_url"`
+	CreatedAt    time.Time   `json:"created_at"`
+	Description  string      `json:"description"`
+	Duration     int         `json:"duration"`
+	ID           int         `json:"id"`
+	Kind         string      `json:"kind"`
+	LastModified time.Time   `json:"last_modified"`
+	Permalink    string      `json:"permalink"`
+	PermalinkURL string      `json:"permalink_url"`
+	Public       bool        `json:"public"`
+	SecretToken  string      `json:"secret_token"`
+	SetType      string      `json:"set_type"`
+	Title        string      `json:"title"`
+	TrackCount   int         `json:"track_count"`
+	Tracks       []metadata2 `json:"tracks"`
+	URI          string      `json:"uri"`
+	Urn          string      `json:"urn"`
+	User         user2       `json:"user"`
+}
+
+// tracksPage is a single page of paginated tracks list
+type tracksPage struct {
+	Collection []metadata2 `json:"collection"`
+	NextHref   string      `json:"next_href"`
+}
+
 type user struct {
 	ID           int    `json:"id"`
 	Kind         string `json:"kind"`
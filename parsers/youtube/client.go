@@ -1,6 +1,7 @@
 package youtube
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -83,18 +84,30 @@ func (c *Client) getStreamURL(ctx context.Context, video *Video, stream *Stream)
 }
 
 func (c *Client) httpGet(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpDo(req)
+}
+
+func (c *Client) httpPost(ctx context.Context, url string, body []byte) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.httpDo(req)
+}
+
+func (c *Client) httpDo(req *http.Request) (resp *http.Response, err error) {
 	client := c.HTTPClient
 	if client == nil {
 		client = http.DefaultClient
 	}
 
 	if c.Debug {
-		log.Println("GET", url)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+		log.Println(req.Method, req.URL)
 	}
 
 	resp, err = client.Do(req)
@@ -0,0 +1,222 @@
+package youtube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrPlaylistIDNotFound = errors.New("playlist id not found")
+	ErrInitialDataMissing = errors.New("no initial data found in playlist page")
+)
+
+var (
+	innertubeKeyPattern     = regexp.MustCompile(`"INNERTUBE_API_KEY"\s*:\s*"([^"]+)"`)
+	innertubeVersionPattern = regexp.MustCompile(`"INNERTUBE_CLIENT_VERSION"\s*:\s*"([^"]+)"`)
+	initialDataPattern      = regexp.MustCompile(`ytInitialData"?\]?\s*=\s*`)
+)
+
+type Playlist struct {
+	ID     string
+	Title  string
+	Author string
+	Videos []PlaylistEntry
+}
+
+type PlaylistEntry struct {
+	ID       string
+	Title    string
+	Author   string
+	Duration time.Duration
+}
+
+// getPlaylist fetches playlist page and then follow continuation tokens until
+// all entries are received or limit reached. 0 limit means no limit
+func (c *Client) getPlaylist(ctx context.Context, url string, limit int) (*Playlist, error) {
+	id, err := extractPlaylistID(url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpGet(ctx, "https://www.youtube.com/playlist?list="+id+"&hl=en")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := parseInitialData(body)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Playlist{ID: id}
+	findKey(data, "playlistMetadataRenderer", func(v interface{}) {
+		if len(p.Title) < 1 {
+			p.Title = text(lookup(v, "title"))
+		}
+	})
+	findKey(data, "videoOwnerRenderer", func(v interface{}) {
+		if len(p.Author) < 1 {
+			p.Author = text(lookup(v, "title"))
+		}
+	})
+	token := p.addEntries(data)
+
+	var apiKey, clientVersion string
+	if matches := innertubeKeyPattern.FindSubmatch(body); matches != nil {
+		apiKey = string(matches[1])
+	}
+	if matches := innertubeVersionPattern.FindSubmatch(body); matches != nil {
+		clientVersion = string(matches[1])
+	}
+
+	for len(token) > 0 && len(apiKey) > 0 {
+		if limit > 0 && len(p.Videos) >= limit {
+			break
+		}
+		data, err := c.browseContinuation(ctx, apiKey, clientVersion, token)
+		if err != nil {
+			return nil, err
+		}
+		token = p.addEntries(data)
+	}
+
+	if limit > 0 && len(p.Videos) > limit {
+		p.Videos = p.Videos[:limit]
+	}
+	return p, nil
+}
+
+// addEntries append every video found in data to playlist and return
+// continuation token for the next page, if any
+func (p *Playlist) addEntries(data interface{}) (token string) {
+	findKey(data, "playlistVideoRenderer", func(v interface{}) {
+		id, _ := lookup(v, "videoId").(string)
+		if len(id) < 1 {
+			return
+		}
+		entry := PlaylistEntry{
+			ID:     id,
+			Title:  text(lookup(v, "title")),
+			Author: text(lookup(v, "shortBylineText")),
+		}
+		if seconds, _ := lookup(v, "lengthSeconds").(string); len(seconds) > 0 {
+			if n, err := strconv.Atoi(seconds); err == nil {
+				entry.Duration = time.Duration(n) * time.Second
+			}
+		}
+		p.Videos = append(p.Videos, entry)
+	})
+	findKey(data, "continuationCommand", func(v interface{}) {
+		if t, ok := lookup(v, "token").(string); ok && len(token) < 1 {
+			token = t
+		}
+	})
+	return token
+}
+
+func (c *Client) browseContinuation(ctx context.Context, apiKey, clientVersion, token string) (interface{}, error) {
+	payload := map[string]interface{}{
+		"context": map[string]interface{}{
+			"client": map[string]string{
+				"clientName":    "WEB",
+				"clientVersion": clientVersion,
+				"hl":            "en",
+			},
+		},
+		"continuation": token,
+	}
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpPost(ctx, "https://www.youtube.com/youtubei/v1/browse?key="+apiKey, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("unable to parse continuation JSON: %w", err)
+	}
+	return data, nil
+}
+
+// parseInitialData find ytInitialData object inside html page and decode it
+func parseInitialData(page []byte) (interface{}, error) {
+	loc := initialDataPattern.FindIndex(page)
+	if loc == nil {
+		return nil, ErrInitialDataMissing
+	}
+	// decoder stops right after first json value, so we don't need
+	// to search for the end of object
+	var data interface{}
+	if err := json.NewDecoder(bytes.NewReader(page[loc[1]:])).Decode(&data); err != nil {
+		return nil, fmt.Errorf("unable to parse initial data JSON: %w", err)
+	}
+	return data, nil
+}
+
+// findKey walk through decoded json and call fn for every value stored under key
+func findKey(v interface{}, key string, fn func(interface{})) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		// keep walking order stable, so entries are found in the same order
+		keys := make([]string, 0, len(node))
+		for k := range node {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == key {
+				fn(node[k])
+				continue
+			}
+			findKey(node[k], key, fn)
+		}
+	case []interface{}:
+		for _, child := range node {
+			findKey(child, key, fn)
+		}
+	}
+}
+
+// lookup return value of object's key or nil if v is not an object
+func lookup(v interface{}, key string) interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return obj[key]
+}
+
+// text return content of youtube's text object, which is either
+// {"simpleText": "..."} or {"runs": [{"text": "..."}, ...]}
+func text(v interface{}) string {
+	if s, ok := lookup(v, "simpleText").(string); ok {
+		return s
+	}
+	runs, _ := lookup(v, "runs").([]interface{})
+	var result string
+	for _, run := range runs {
+		if s, ok := lookup(run, "text").(string); ok {
+			result += s
+		}
+	}
+	return result
+}
@@ -84,13 +84,14 @@ type PlayerResponseData struct {
 		} `json:"playerCaptionsTracklistRenderer"`
 	} `json:"captions"`
 	VideoDetails struct {
-		VideoID          string `json:"videoId"`
-		Title            string `json:"title"`
-		LengthSeconds    string `json:"lengthSeconds"`
-		ChannelID        string `json:"channelId"`
-		IsOwnerViewing   bool   `json:"isOwnerViewing"`
-		ShortDescription string `json:"shortDescription"`
-		IsCrawlable      bool   `json:"isCrawlable"`
+		VideoID          string   `json:"videoId"`
+		Title            string   `json:"title"`
+		LengthSeconds    string   `json:"lengthSeconds"`
+		ChannelID        string   `json:"channelId"`
+		IsOwnerViewing   bool     `json:"isOwnerViewing"`
+		ShortDescription string   `json:"shortDescription"`
+		Keywords         []string `json:"keywords"`
+		IsCrawlable      bool     `json:"isCrawlable"`
 		Thumbnail        struct {
 			Thumbnails []struct {
 				URL    string `json:"url"`
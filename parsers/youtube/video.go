@@ -17,6 +17,24 @@ type Video struct {
 	Author      string
 	Duration    time.Duration
 	PublishDate time.Time
+	Description string
+	Keywords    []string
+	Thumbnails  []Thumbnail
+	Captions    []Caption
+}
+
+type Caption struct {
+	URL      string
+	Language string
+	Name     string
+	// Kind is "asr" for automatic speech recognition
+	Kind string
+}
+
+type Thumbnail struct {
+	URL    string
+	Width  int
+	Height int
 }
 
 type Stream struct {
@@ -25,6 +43,8 @@ type Stream struct {
 	MimeType string `json:"mimeType"`
 	Quality  string `json:"quality"`
 	Cipher   string `json:"signatureCipher"`
+	// Bitrate in kbit/s, filled from outer format's info
+	Bitrate int `json:"-"`
 }
 
 func (v *Video) FindStreamByQuality(quality string) *Stream {
@@ -73,6 +93,34 @@ func (v *Video) parseVideoInfo(info string) error {
 
 	v.Title = prData.VideoDetails.Title
 	v.Author = prData.VideoDetails.Author
+	v.Keywords = prData.VideoDetails.Keywords
+	v.Description = prData.Microformat.PlayerMicroformatRenderer.Description.SimpleText
+	if v.Description == "" {
+		v.Description = prData.VideoDetails.ShortDescription
+	}
+
+	thumbnails := append(prData.VideoDetails.Thumbnail.Thumbnails,
+		prData.Microformat.PlayerMicroformatRenderer.Thumbnail.Thumbnails...)
+	seen := make(map[string]bool, len(thumbnails))
+	for _, t := range thumbnails {
+		if t.URL == "" || seen[t.URL] {
+			continue
+		}
+		seen[t.URL] = true
+		v.Thumbnails = append(v.Thumbnails, Thumbnail{URL: t.URL, Width: t.Width, Height: t.Height})
+	}
+
+	for _, c := range prData.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks {
+		if c.BaseURL == "" {
+			continue
+		}
+		v.Captions = append(v.Captions, Caption{
+			URL:      c.BaseURL,
+			Language: c.LanguageCode,
+			Name:     c.Name.SimpleText,
+			Kind:     c.Kind,
+		})
+	}
 
 	if seconds, _ := strconv.Atoi(prData.Microformat.PlayerMicroformatRenderer.LengthSeconds); seconds > 0 {
 		v.Duration = time.Duration(seconds) * time.Second
@@ -124,10 +172,22 @@ func parseStreams(prData PlayerResponseData) ([]Stream, error) {
 	}
 
 	for _, format := range prData.StreamingData.Formats {
+		format.Stream.Bitrate = kbps(format.AverageBitrate, format.Bitrate)
 		filterFormat(format.Stream)
 	}
 	for _, format := range prData.StreamingData.AdaptiveFormats {
+		format.Stream.Bitrate = kbps(format.AverageBitrate, format.Bitrate)
 		filterFormat(format.Stream)
 	}
 	return streams, nil
 }
+
+// kbps convert first non-zero bitrate from bit/s to kbit/s
+func kbps(bitrates ...int) int {
+	for _, b := range bitrates {
+		if b > 0 {
+			return (b + 500) / 1000
+		}
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package youtube
 
 import (
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -30,3 +31,32 @@ func extractVideoID(videoID string) (string, error) {
 
 	return videoID, nil
 }
+
+var playlistIDRegexp = regexp.MustCompile(`^[\w-]{2,}$`)
+
+func extractPlaylistID(playlistURL string) (string, error) {
+	u, err := url.Parse(playlistURL)
+	if err != nil {
+		return "", err
+	}
+	id := u.Query().Get("list")
+	if len(id) < 1 {
+		// maybe it's id itself
+		id = playlistURL
+	}
+	if !playlistIDRegexp.MatchString(id) {
+		return "", ErrPlaylistIDNotFound
+	}
+	return id, nil
+}
+
+// hasVideoID report whether url point to certain video
+func hasVideoID(u url.URL) bool {
+	if len(u.Query().Get("v")) > 0 {
+		return true
+	}
+	if strings.Contains(u.Hostname(), "youtu.be") && len(strings.Trim(u.Path, "/")) > 0 {
+		return true
+	}
+	return strings.HasPrefix(u.Path, "/embed/") || strings.HasPrefix(u.Path, "/v/")
+}
@@ -1,10 +1,14 @@
 package youtube
 
 import (
+	"context"
+	"fmt"
 	"github.com/camelva/erzo/engine"
 	"github.com/camelva/erzo/parsers"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 )
 
 type Extractor struct {
@@ -12,6 +16,8 @@ type Extractor struct {
 	urlPattern string
 	apiURL     string
 	baseURL    string
+	// httpClient is used for every request. If nil, http.DefaultClient is used
+	httpClient *http.Client
 }
 
 var IE Extractor
@@ -20,6 +26,7 @@ var audioITags = []int{251, 250, 249, 172, 171, 328, 325, 258, 256, 141, 140, 13
 
 func init() {
 	IE = Extractor{
+		name:       "YouTube",
 		urlPattern: `(?:www\.)?(?:youtube\.com|youtu.be)`,
 		apiURL:     "https://api.soundcloud.com/",
 		baseURL:    "https://youtube.com/",
@@ -37,9 +44,78 @@ func (ie Extractor) Compatible(u url.URL) bool {
 	return ok
 }
 
-func (ie Extractor) Extract(u url.URL) (*parsers.ExtractorInfo, error) {
-	c := Client{Debug: false}
-	video, err := c.GetVideo(u.String())
+// Extract extract info about single video. Urls pointing both to video and
+// playlist, like watch?v=...&list=..., always give the video: the whole
+// playlist is extracted only by ExtractCollection
+func (ie Extractor) Extract(ctx context.Context, u url.URL) (*parsers.ExtractorInfo, error) {
+	if !hasVideoID(u) && len(u.Query().Get("list")) > 0 {
+		return nil, parsers.ErrFormatNotSupported{Format: "playlist"}
+	}
+	c := Client{Debug: false, HTTPClient: ie.httpClient}
+	return extractVideo(ctx, &c, u.String())
+}
+
+// ExtractCollection extract info about every video of playlist. Urls
+// pointing both to video and playlist give the whole playlist, unless
+// opts.NoPlaylist is set
+func (ie Extractor) ExtractCollection(ctx context.Context, u url.URL, opts parsers.CollectionOptions) (*parsers.Collection, error) {
+	c := Client{Debug: false, HTTPClient: ie.httpClient}
+	if len(u.Query().Get("list")) < 1 {
+		return nil, parsers.ErrFormatNotSupported{Format: "video"}
+	}
+	if opts.NoPlaylist && hasVideoID(u) {
+		info, err := extractVideo(ctx, &c, u.String())
+		if err != nil {
+			return nil, err
+		}
+		return &parsers.Collection{
+			Title:     info.Title,
+			Permalink: info.Permalink,
+			Uploader:  info.Uploader,
+			Entries:   []parsers.CollectionEntry{{Info: info}},
+		}, nil
+	}
+
+	// with date filtering we can't know how many videos will be skipped
+	limit := opts.Limit
+	if !opts.After.IsZero() {
+		limit = 0
+	}
+	playlist, err := c.getPlaylist(ctx, u.String(), limit)
+	if err != nil {
+		return nil, parsers.ErrCantContinue{Reason: err.Error()}
+	}
+
+	collection := &parsers.Collection{
+		Title:     playlist.Title,
+		Permalink: playlist.ID,
+		Uploader:  playlist.Author,
+		Entries:   make([]parsers.CollectionEntry, 0, len(playlist.Videos)),
+	}
+	for _, entry := range playlist.Videos {
+		if opts.Limit > 0 && len(collection.Entries) >= opts.Limit {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		info, err := extractVideo(ctx, &c, entry.ID)
+		if err != nil {
+			collection.Entries = append(collection.Entries, parsers.CollectionEntry{
+				Err: parsers.ErrCantContinue{Reason: err.Error()},
+			})
+			continue
+		}
+		if !opts.After.IsZero() && info.Timestamp.Before(opts.After) {
+			continue
+		}
+		collection.Entries = append(collection.Entries, parsers.CollectionEntry{Info: info})
+	}
+	return collection, nil
+}
+
+func extractVideo(ctx context.Context, c *Client, videoURL string) (*parsers.ExtractorInfo, error) {
+	video, err := c.GetVideoContext(ctx, videoURL)
 	if err != nil {
 		return nil, err
 	}
@@ -50,9 +126,11 @@ func (ie Extractor) Extract(u url.URL) (*parsers.ExtractorInfo, error) {
 	}
 
 	// add first available iTag for external usage
-	audioITags = append(audioITags, video.Streams[0].ItagNo)
+	iTags := make([]int, 0, len(audioITags)+1)
+	iTags = append(iTags, audioITags...)
+	iTags = append(iTags, video.Streams[0].ItagNo)
 
-	for _, tag := range audioITags {
+	for _, tag := range iTags {
 		if len(formats) > 2 {
 			break
 		}
@@ -60,29 +138,96 @@ func (ie Extractor) Extract(u url.URL) (*parsers.ExtractorInfo, error) {
 		if stream == nil {
 			continue
 		}
-		uri, err := c.GetStreamURL(video, stream)
+		uri, err := c.getStreamURL(ctx, video, stream)
 		if err != nil {
 			continue
 		}
 
+		container := streamContainer(stream.MimeType)
 		f := parsers.Format{
 			Url:      uri,
-			Ext:      "",
-			Type:     "",
+			Ext:      container,
+			Type:     container,
 			Protocol: "https",
 			Score:    0,
+			Codec:    parsers.CodecFromMime(stream.MimeType),
+			Bitrate:  stream.Bitrate,
 		}
 		formats = append(formats, f)
 	}
 
 	info := parsers.ExtractorInfo{
-		Permalink:  video.Title,
-		Uploader:   video.Author,
-		Timestamp:  video.PublishDate,
-		Title:      video.Title,
-		Thumbnails: nil,
-		Duration:   video.Duration,
-		Formats:    formats,
+		ID:          video.ID,
+		Permalink:   video.Title,
+		Uploader:    video.Author,
+		Timestamp:   video.PublishDate,
+		Title:       video.Title,
+		Description: video.Description,
+		Tags:        video.Keywords,
+		Thumbnails:  videoArtworks(video.Thumbnails),
+		Duration:    video.Duration,
+		WebPageURL:  "https://www.youtube.com/watch?v=" + video.ID,
+		Captions:    videoCaptions(video.Captions),
+		Chapters:    parsers.ParseChapters(video.Description, video.Duration),
+		Formats:     formats,
 	}
 	return &info, nil
 }
+
+// videoArtworks convert video's thumbnails into artworks. The biggest one
+// is "original", others are keyed by their size, like "480x360"
+func videoArtworks(thumbnails []Thumbnail) map[string]parsers.Artwork {
+	if len(thumbnails) < 1 {
+		return nil
+	}
+	side := func(t Thumbnail) int {
+		if t.Width < t.Height {
+			return t.Width
+		}
+		return t.Height
+	}
+	biggest := 0
+	for i, t := range thumbnails {
+		if t.Width*t.Height > thumbnails[biggest].Width*thumbnails[biggest].Height {
+			biggest = i
+		}
+	}
+	artworks := make(map[string]parsers.Artwork, len(thumbnails))
+	for i, t := range thumbnails {
+		kind := fmt.Sprintf("%dx%d", t.Width, t.Height)
+		if i == biggest {
+			kind = "original"
+		}
+		artworks[kind] = parsers.Artwork{Type: kind, URL: t.URL, Size: side(t)}
+	}
+	return artworks
+}
+
+// videoCaptions convert video's caption tracks
+func videoCaptions(tracks []Caption) []parsers.Caption {
+	captions := make([]parsers.Caption, 0, len(tracks))
+	for _, c := range tracks {
+		captions = append(captions, parsers.Caption{
+			Language:      c.Language,
+			Name:          c.Name,
+			URL:           c.URL,
+			AutoGenerated: c.Kind == "asr",
+		})
+	}
+	return captions
+}
+
+// streamContainer return container's extension of stream with given mime type,
+// like `audio/mp4; codecs="mp4a.40.2"`
+func streamContainer(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "audio/mp4"):
+		return "m4a"
+	case strings.HasPrefix(mimeType, "video/mp4"):
+		return "mp4"
+	case strings.HasPrefix(mimeType, "audio/webm"), strings.HasPrefix(mimeType, "video/webm"):
+		return "webm"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,285 @@
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camelva/erzo/parsers"
+)
+
+// fakeYouTube answer requests of youtube client without network. It serve
+// playlist "PLtest" of 5 videos: 2 on playlist page and the rest on two
+// continuation pages
+type fakeYouTube struct {
+	mu     sync.Mutex
+	browse []string
+}
+
+func (f *fakeYouTube) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	f.serve(w, req)
+	return w.Result(), nil
+}
+
+func (f *fakeYouTube) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/playlist":
+		if r.URL.Query().Get("list") != "PLtest" {
+			http.NotFound(w, r)
+			return
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"playlistMetadataRenderer": map[string]interface{}{"title": map[string]string{"simpleText": "Test mix"}},
+			},
+			"sidebar": map[string]interface{}{
+				"videoOwnerRenderer": map[string]interface{}{"title": runs("Owner")},
+			},
+			"contents": playlistPage([]int{1, 2}, "page2"),
+		})
+		fmt.Fprintf(w, `<html><script>ytcfg.set({"INNERTUBE_API_KEY": "key","INNERTUBE_CLIENT_VERSION":"2.20210101"});</script>`+
+			`<script>var ytInitialData = %s;</script></html>`, data)
+	case "/youtubei/v1/browse":
+		var body struct {
+			Continuation string `json:"continuation"`
+		}
+		if r.URL.Query().Get("key") != "key" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.browse = append(f.browse, body.Continuation)
+		f.mu.Unlock()
+		var contents []interface{}
+		switch body.Continuation {
+		case "page2":
+			contents = playlistPage([]int{3, 4}, "page3")
+		case "page3":
+			contents = playlistPage([]int{5}, "")
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"onResponseReceivedActions": []interface{}{
+				map[string]interface{}{"appendContinuationItemsAction": map[string]interface{}{"continuationItems": contents}},
+			},
+		})
+	case "/get_video_info":
+		id := r.URL.Query().Get("video_id")
+		var n int
+		if _, err := fmt.Sscanf(id, "video%06d", &n); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		response, _ := json.Marshal(map[string]interface{}{
+			"playabilityStatus": map[string]string{"status": "OK"},
+			"videoDetails":      map[string]string{"title": fmt.Sprintf("Video %d", n), "author": "Artist"},
+			"microformat": map[string]interface{}{
+				"playerMicroformatRenderer": map[string]string{
+					"publishDate":   fmt.Sprintf("2020-01-%02d", n),
+					"lengthSeconds": "61",
+				},
+			},
+			"streamingData": map[string]interface{}{
+				"adaptiveFormats": []interface{}{map[string]interface{}{
+					"itag":     140,
+					"url":      "https://cdn.test/" + id + ".m4a",
+					"mimeType": `audio/mp4; codecs="mp4a.40.2"`,
+					"bitrate":  128000,
+				}},
+			},
+		})
+		_, _ = w.Write([]byte(url.Values{"status": {"ok"}, "player_response": {string(response)}}.Encode()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// browseTokens return continuation tokens of every browse request
+func (f *fakeYouTube) browseTokens() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tokens []string
+	return append(tokens, f.browse...)
+}
+
+// videoID return id of n-th fixture video
+func videoID(n int) string {
+	return fmt.Sprintf("video%06d", n)
+}
+
+// runs return youtube's text object of runs
+func runs(s string) map[string]interface{} {
+	return map[string]interface{}{"runs": []interface{}{map[string]string{"text": s}}}
+}
+
+// playlistPage return playlist items for given videos, followed by
+// continuation item if token isn't empty
+func playlistPage(videos []int, token string) []interface{} {
+	items := make([]interface{}, 0, len(videos)+1)
+	for _, n := range videos {
+		items = append(items, map[string]interface{}{
+			"playlistVideoRenderer": map[string]interface{}{
+				"videoId":         videoID(n),
+				"title":           runs(fmt.Sprintf("Video %d", n)),
+				"shortBylineText": runs("Artist"),
+				"lengthSeconds":   "61",
+			},
+		})
+	}
+	if len(token) > 0 {
+		items = append(items, map[string]interface{}{
+			"continuationItemRenderer": map[string]interface{}{
+				"continuationEndpoint": map[string]interface{}{
+					"continuationCommand": map[string]string{"token": token},
+				},
+			},
+		})
+	}
+	return items
+}
+
+func TestParseInitialData(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		want    interface{}
+		wantErr bool
+	}{
+		{"variable", `<script>var ytInitialData = {"a": [1]};</script>`, map[string]interface{}{"a": []interface{}{1.0}}, false},
+		{"window key", `<script>window["ytInitialData"] = {"a": "}"};window.x = {};</script>`, map[string]interface{}{"a": "}"}, false},
+		{"missing", `<script>var ytInitialPlayerResponse = {};</script>`, nil, true},
+		{"broken", `<script>var ytInitialData = {"a": </script>`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInitialData([]byte(tt.page))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInitialData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInitialData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_getPlaylist(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		videos int
+		tokens []string
+	}{
+		{"all", 0, 5, []string{"page2", "page3"}},
+		{"limit inside continuation", 3, 3, []string{"page2"}},
+		{"limit of the first page", 2, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yt := &fakeYouTube{}
+			c := Client{HTTPClient: &http.Client{Transport: yt}}
+			p, err := c.getPlaylist(context.Background(), "https://www.youtube.com/playlist?list=PLtest", tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.ID != "PLtest" || p.Title != "Test mix" || p.Author != "Owner" {
+				t.Errorf("playlist = %q %q by %q", p.ID, p.Title, p.Author)
+			}
+			if len(p.Videos) != tt.videos {
+				t.Fatalf("playlist has %d videos, want %d", len(p.Videos), tt.videos)
+			}
+			for i, v := range p.Videos {
+				want := PlaylistEntry{ID: videoID(i + 1), Title: fmt.Sprintf("Video %d", i+1), Author: "Artist", Duration: 61 * time.Second}
+				if v != want {
+					t.Errorf("video %d = %+v, want %+v", i, v, want)
+				}
+			}
+			if tokens := yt.browseTokens(); !reflect.DeepEqual(tokens, tt.tokens) {
+				t.Errorf("continuations = %q, want %q", tokens, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestHasVideoID(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://www.youtube.com/watch?v=video000001&list=PLtest", true},
+		{"https://www.youtube.com/watch?v=video000001", true},
+		{"https://youtu.be/video000001?list=PLtest", true},
+		{"https://www.youtube.com/embed/video000001", true},
+		{"https://www.youtube.com/playlist?list=PLtest", false},
+		{"https://www.youtube.com/watch?list=PLtest", false},
+		{"https://youtu.be/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			if got := hasVideoID(*u); got != tt.want {
+				t.Errorf("hasVideoID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractor_MixedURL(t *testing.T) {
+	ie := IE
+	ie.httpClient = &http.Client{Transport: &fakeYouTube{}}
+	u, _ := url.Parse("https://www.youtube.com/watch?v=" + videoID(3) + "&list=PLtest")
+
+	tests := []struct {
+		name string
+		opts parsers.CollectionOptions
+		want []string
+	}{
+		{"playlist", parsers.CollectionOptions{}, []string{videoID(1), videoID(2), videoID(3), videoID(4), videoID(5)}},
+		{"no playlist", parsers.CollectionOptions{NoPlaylist: true}, []string{videoID(3)}},
+		{"limit", parsers.CollectionOptions{Limit: 2}, []string{videoID(1), videoID(2)}},
+		{"after", parsers.CollectionOptions{After: time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)}, []string{videoID(4), videoID(5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection, err := ie.ExtractCollection(context.Background(), *u, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range collection.Entries {
+				if entry.Err != nil {
+					t.Fatal(entry.Err)
+				}
+				got = append(got, entry.Info.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractCollection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// single video is extracted regardless of playlist
+	info, err := ie.Extract(context.Background(), *u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != videoID(3) || info.Title != "Video 3" || len(info.Formats) < 1 ||
+		!strings.HasSuffix(info.Formats[0].Url, videoID(3)+".m4a") {
+		t.Errorf("Extract() = %+v", info)
+	}
+	playlist, _ := url.Parse("https://www.youtube.com/playlist?list=PLtest")
+	if _, err := ie.Extract(context.Background(), *playlist); err == nil {
+		t.Errorf("Extract() of playlist: expected error")
+	}
+}
@@ -1,19 +1,26 @@
 package utils
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 )
 
+// UserAgent is sent with every request
+const UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:73.0) Gecko/20100101 Firefox/73.0"
+
 func Fetch(u *url.URL) ([]byte, error) {
-	var userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:73.0) Gecko/20100101 Firefox/73.0"
+	return FetchContext(context.Background(), u)
+}
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+// FetchContext same as Fetch, but request will be aborted when ctx is done
+func FetchContext(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("User-Agent", UserAgent)
 
 	client := new(http.Client)
 	resp, err := client.Do(req)